@@ -0,0 +1,9 @@
+// Package assets holds the files embedded in the binary.
+package assets
+
+import "embed"
+
+// Prompts holds the prompts sent to the LLM, named speakrine_prompt_<name>_v<version>.txt in the prompt directory.
+//
+//go:embed prompt/*.txt
+var Prompts embed.FS
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/cl3mcg/speakrine/functions"
+)
+
+// runCommand runs the one-off command given on the command line instead of the periodic process.
+//
+// Parameters:
+//   - args: The command line arguments, without the program name.
+//
+// Returns:
+//   - int: The exit code of the command.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "unhealthy-feeds":
+		return listUnhealthyFeeds()
+	case "repaired-feeds":
+		return listRepairedFeeds()
+	case "resume-feed":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: speakrine resume-feed <feed_id>")
+			return 2
+		}
+		return resumeFeed(args[1])
+	case "import-opml":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Usage: speakrine import-opml <user_id> <file>")
+			return 2
+		}
+		return importOPML(args[1], args[2])
+	case "export-opml":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintln(os.Stderr, "Usage: speakrine export-opml <user_id> [file]")
+			return 2
+		}
+		file := ""
+		if len(args) == 3 {
+			file = args[2]
+		}
+		return exportOPML(args[1], file)
+	case "discover":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintln(os.Stderr, "Usage: speakrine discover <page_url> [user_id]")
+			return 2
+		}
+		userId := ""
+		if len(args) == 3 {
+			userId = args[2]
+		}
+		return discoverFeeds(args[1], userId)
+	case "items-by-category":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Usage: speakrine items-by-category <user_id> <category>")
+			return 2
+		}
+		return listItemsByCategory(args[1], args[2])
+	case "reclean":
+		return recleanItems(args[1:])
+	case "items-to-review":
+		return listItemsToReview()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "Available commands: unhealthy-feeds, repaired-feeds, resume-feed, import-opml, export-opml, discover, items-by-category, reclean, items-to-review")
+		return 2
+	}
+}
+
+// listUnhealthyFeeds prints the feeds that are suspended or whose last fetch failed.
+func listUnhealthyFeeds() int {
+	feeds, err := functions.ListUnhealthyFeeds()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list the unhealthy feeds:", err)
+		return 1
+	}
+	if len(feeds) == 0 {
+		fmt.Println("All feeds are healthy")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tFAILURES\tHTTP\tLAST SUCCESS\tURL\tLAST ERROR")
+	for _, feed := range feeds {
+		lastSuccess := "Never"
+		if !feed.LastSuccess.IsZero() {
+			lastSuccess = feed.LastSuccess.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", feed.Id, feed.CommonName, feed.Status, feed.FailureCount, feed.LastHttpStatus, lastSuccess, feed.Url, feed.LastError)
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// listRepairedFeeds prints the feeds whose last fetched document had to be repaired, so that their publisher can be told.
+func listRepairedFeeds() int {
+	feeds, err := functions.ListRepairedFeeds()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list the repaired feeds:", err)
+		return 1
+	}
+	if len(feeds) == 0 {
+		fmt.Println("No feed needed a repair")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tREPAIRED AT\tURL\tREPAIRS")
+	for _, feed := range feeds {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", feed.Id, feed.CommonName, feed.LastRepairAt.Format("2006-01-02 15:04"), feed.Url, feed.LastRepair)
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// resumeFeed reactivates a suspended feed.
+func resumeFeed(strFeedId string) int {
+	feedId, err := strconv.Atoi(strFeedId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid feed ID %q\n", strFeedId)
+		return 2
+	}
+
+	found, err := functions.ResumeFeed(feedId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to resume the feed:", err)
+		return 1
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "No feed with ID %d\n", feedId)
+		return 1
+	}
+
+	fmt.Printf("Feed %d resumed, it will be fetched on the next cycle\n", feedId)
+	return 0
+}
+
+// importOPML subscribes a user to the feeds listed in an OPML file.
+func importOPML(strUserId string, path string) int {
+	userId, err := strconv.Atoi(strUserId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid user ID %q\n", strUserId)
+		return 2
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open the OPML file:", err)
+		return 1
+	}
+	defer file.Close()
+
+	imported, err := functions.ImportOPML(userId, file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to import the OPML file:", err)
+		return 1
+	}
+
+	fmt.Printf("%d feeds imported for user %d\n", imported, userId)
+	return 0
+}
+
+// exportOPML writes the feeds of a user as an OPML document, to a file or to the standard output.
+func exportOPML(strUserId string, path string) int {
+	userId, err := strconv.Atoi(strUserId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid user ID %q\n", strUserId)
+		return 2
+	}
+
+	out := os.Stdout
+	if path != "" {
+		out, err = os.Create(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create the OPML file:", err)
+			return 1
+		}
+		defer out.Close()
+	}
+
+	if err := functions.ExportOPML(userId, out); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to export the OPML file:", err)
+		return 1
+	}
+	return 0
+}
+
+// discoverFeeds prints the feeds found on a website and, if a user ID is given, subscribes the user to the first one.
+func discoverFeeds(pageUrl string, strUserId string) int {
+	userId := 0
+	if strUserId != "" {
+		var err error
+		userId, err = strconv.Atoi(strUserId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid user ID %q\n", strUserId)
+			return 2
+		}
+	}
+
+	candidates, err := functions.DiscoverFeeds(context.Background(), pageUrl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to discover the feeds:", err)
+		return 1
+	}
+	if len(candidates) == 0 {
+		fmt.Println("No feed found")
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tTITLE\tURL")
+	for _, candidate := range candidates {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", candidate.FeedType, candidate.Title, candidate.Url)
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+
+	if userId == 0 {
+		return 0
+	}
+	created, err := functions.SubscribeFeed(userId, candidates[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to subscribe to the feed:", err)
+		return 1
+	}
+	if created {
+		fmt.Printf("User %d subscribed to %s\n", userId, candidates[0].Url)
+	} else {
+		fmt.Printf("User %d is already subscribed to %s\n", userId, candidates[0].Url)
+	}
+	return 0
+}
+
+// listItemsByCategory prints the most recent items of a user tagged with a category.
+func listItemsByCategory(strUserId string, category string) int {
+	userId, err := strconv.Atoi(strUserId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid user ID %q\n", strUserId)
+		return 2
+	}
+
+	items, err := functions.ListItemsByCategory(userId, category, 100)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list the items:", err)
+		return 1
+	}
+	if len(items) == 0 {
+		fmt.Println("No item found")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFEED\tPUBLISHED\tKIND\tTITLE\tLINK")
+	for _, item := range items {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", item.Id, item.RssFeedId, item.PublishedDate.Format("2006-01-02 15:04"), item.Kind(), item.Title, item.Link)
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// recleanItems queues the items cleaned with an older prompt version to be cleaned again, optionally only for some feeds.
+func recleanItems(strFeedIds []string) int {
+	var feedIds []int
+	for _, strFeedId := range strFeedIds {
+		feedId, err := strconv.Atoi(strFeedId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid feed ID %q\n", strFeedId)
+			return 2
+		}
+		feedIds = append(feedIds, feedId)
+	}
+
+	result, err := functions.RecleanItems(feedIds)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to queue the items to clean again:", err)
+		return 1
+	}
+	fmt.Printf("%d items queued to be cleaned again with prompt %s\n", result.Requeued, result.PromptVersion)
+	if result.RawDropped > 0 {
+		fmt.Printf("%d items cannot be cleaned again, their raw content was dropped by RETENTION_DROP_RAW\n", result.RawDropped)
+	}
+	return 0
+}
+
+// listItemsToReview prints the cleaned items whose content may not be faithful to the original article.
+func listItemsToReview() int {
+	items, err := functions.ListItemsNeedingReview(100)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list the items to review:", err)
+		return 1
+	}
+	if len(items) == 0 {
+		fmt.Println("No item needs a review")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFEED\tTITLE\tLINK\tISSUES")
+	for _, item := range items {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", item.Id, item.RssFeedId, item.Title, item.Link, item.FidelityIssue)
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
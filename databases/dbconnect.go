@@ -5,21 +5,25 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gowebly/helpers"
 	_ "github.com/joho/godotenv/autoload"
 )
 
-// The db variable holds the database connection pool.
-var db *sql.DB
+// The db variable holds the database connection pool, opened by the first call to GetDB.
+var (
+	db     *sql.DB
+	dbOnce sync.Once
+)
 
-// init initializes the database connection using environment variables.
+// connect initializes the database connection using environment variables.
 //
 // This function ensures that all required environment variables are present and then
 // establishes a connection to the MySQL database. If any step fails, the
 // program logs the error and terminates.
-func init() {
+func connect() {
 	// Load environment variables for database connection.
 	dbHost := gowebly.Getenv("DB_HOST", "")
 	dbPort := gowebly.Getenv("DB_PORT", "")
@@ -34,7 +38,8 @@ func init() {
 	}
 
 	// Construct the Data Source CommonName (DSN) for connecting to the database.
-	dsn := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", dbUser, dbPass, dbHost, dbPort, dbName)
+	// Dates are scanned as time.Time and the session runs in UTC, so that NOW() and the dates sent by the application agree.
+	dsn := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?parseTime=true&time_zone=%%27%%2B00%%3A00%%27", dbUser, dbPass, dbHost, dbPort, dbName)
 
 	// Open a connection to the database.
 	var err error
@@ -56,9 +61,11 @@ func init() {
 
 // GetDB returns a pointer to the sql.DB instance representing the database connection.
 // This function allows other packages to use the established database connection.
+// The connection is established by the first call, so that the packages using it can be loaded without a database, in tests.
 //
 // Returns:
 //   - *sql.DB - A pointer to the database connection instance.
 func GetDB() *sql.DB {
+	dbOnce.Do(connect)
 	return db
 }
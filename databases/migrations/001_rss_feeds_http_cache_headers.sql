@@ -0,0 +1,5 @@
+-- Store the HTTP caching headers of each feed so that the fetcher can issue conditional requests
+-- (If-None-Match / If-Modified-Since) and skip feeds that have not changed since the last fetch.
+ALTER TABLE rss_feeds
+    ADD COLUMN etag VARCHAR(255) DEFAULT NULL AFTER last_update, -- ETag response header returned by the last successful fetch
+    ADD COLUMN last_modified VARCHAR(64) DEFAULT NULL AFTER etag; -- Last-Modified response header returned by the last successful fetch
@@ -0,0 +1,7 @@
+-- Schedule the fetch of each feed individually, from its publication cadence and the number of fetches
+-- that failed in a row, instead of fetching every feed once its last update is older than 15 minutes.
+ALTER TABLE rss_feeds
+    ADD COLUMN fetch_interval INT UNSIGNED DEFAULT NULL AFTER last_modified, -- Polling interval in minutes, computed from the publication cadence of the feed
+    ADD COLUMN next_fetch_at TIMESTAMP DEFAULT NULL AFTER fetch_interval, -- Time at which the feed should be fetched next
+    ADD COLUMN consecutive_failures SMALLINT UNSIGNED DEFAULT 0 NOT NULL AFTER next_fetch_at, -- Number of fetches that failed in a row
+    ADD INDEX (next_fetch_at);
@@ -0,0 +1,7 @@
+-- Track the fetch state of each feed, so that broken feeds are suspended automatically after too many
+-- consecutive failures and can be listed with the `unhealthy-feeds` command.
+ALTER TABLE rss_feeds
+    ADD COLUMN status VARCHAR(16) DEFAULT 'active' NOT NULL CONSTRAINT rss_feeds_status_check CHECK (status IN ('active', 'suspended')) AFTER consecutive_failures, -- Fetch status of the feed
+    ADD COLUMN last_error TEXT DEFAULT NULL AFTER status, -- Error returned by the last failed fetch
+    ADD COLUMN last_http_status SMALLINT UNSIGNED DEFAULT NULL AFTER last_error, -- HTTP status code returned by the last fetch
+    ADD COLUMN last_success_at TIMESTAMP DEFAULT NULL AFTER last_http_status; -- Last time the feed was fetched successfully
@@ -0,0 +1,7 @@
+-- Track the permanent redirects of each feed, so that its URL is rewritten once the redirect is stable,
+-- and allow feeds answering 410 Gone to be retired.
+ALTER TABLE rss_feeds
+    DROP CHECK rss_feeds_status_check,
+    ADD CONSTRAINT rss_feeds_status_check CHECK (status IN ('active', 'suspended', 'retired')),
+    ADD COLUMN redirect_url VARCHAR(767) DEFAULT NULL AFTER last_success_at, -- URL the feed was permanently redirected to at the last fetches
+    ADD COLUMN redirect_count SMALLINT UNSIGNED DEFAULT 0 NOT NULL AFTER redirect_url; -- Number of fetches in a row redirected to redirect_url
@@ -0,0 +1,4 @@
+-- Store the CSS selectors used to scrape the items of websites without feeds, for feeds of type 'scrap'.
+-- Example: {"list": "article.post", "link": "h2 a", "title": "h2", "date": "time", "date_format": "2006-01-02", "summary": ".excerpt"}
+ALTER TABLE rss_feeds
+    ADD COLUMN scrap_config JSON DEFAULT NULL AFTER categories; -- CSS selectors used to scrape the page of a feed of type 'scrap'
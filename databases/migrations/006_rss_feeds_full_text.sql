@@ -0,0 +1,3 @@
+-- Allow feeds that only publish a teaser to have the full article of their items downloaded and extracted.
+ALTER TABLE rss_feeds
+    ADD COLUMN fetch_full_text BOOL DEFAULT FALSE NOT NULL AFTER scrap_config; -- Download the full article of each item instead of keeping the teaser of the feed
@@ -0,0 +1,4 @@
+-- Store the categories advertised by the channel of each feed, apart from the categories chosen by the user.
+-- The categories of the items are now filled in by the fetcher.
+ALTER TABLE rss_feeds
+    ADD COLUMN source_categories JSON DEFAULT NULL AFTER categories; -- Categories advertised by the channel of the feed, normalized
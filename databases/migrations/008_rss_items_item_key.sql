@@ -0,0 +1,29 @@
+-- Scope the identity of items to their feed instead of requiring globally unique GUIDs, so that several users
+-- can subscribe to the same feed, and identify items without GUID by their link, or by their title and publication date.
+-- The expression computing item_key must stay in line with itemIdentityKey in functions/rss_identity.go.
+ALTER TABLE rss_items
+    ADD COLUMN item_key CHAR(64) DEFAULT NULL AFTER guid; -- SHA-256 of the GUID, or of the link, or of the title and publication date, identifying the entry within its feed
+
+UPDATE rss_items
+SET guid = NULLIF(TRIM(guid), ''),
+    item_key = SHA2(
+        CASE
+            WHEN TRIM(COALESCE(guid, '')) <> '' THEN CONCAT('guid:', TRIM(guid))
+            WHEN TRIM(link) <> '' THEN CONCAT('link:', TRIM(link))
+            ELSE CONCAT('title:', title, '|', COALESCE(DATE_FORMAT(published_date, '%Y-%m-%dT%H:%i:%sZ'), ''))
+        END,
+        256
+    );
+
+-- Keep the oldest copy of the items that now share the same identity within a feed.
+DELETE duplicate
+FROM rss_items AS duplicate
+JOIN rss_items AS original
+    ON original.rss_feed_id = duplicate.rss_feed_id
+    AND original.item_key = duplicate.item_key
+    AND original.id < duplicate.id;
+
+ALTER TABLE rss_items
+    DROP INDEX guid,
+    MODIFY COLUMN item_key CHAR(64) NOT NULL,
+    ADD UNIQUE (rss_feed_id, item_key);
@@ -0,0 +1,16 @@
+-- Detect updated entries from a hash of their content, and keep the previous versions of updated entries.
+ALTER TABLE rss_items
+    ADD COLUMN content_hash CHAR(64) DEFAULT NULL AFTER content_formatted; -- SHA-256 of the title, summary and content of the entry as published by the feed, used to detect updates
+
+CREATE TABLE rss_item_revisions (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY, -- Unique identifier for each revision
+    rss_item_id INT UNSIGNED NOT NULL, -- Foreign key linking to the revised RSS entry
+    title VARCHAR(255) NOT NULL, -- The title of the entry before the update
+    summary_raw TEXT DEFAULT NULL, -- The summary of the entry before the update
+    content_raw LONGTEXT DEFAULT NULL, -- The content of the entry before the update
+    content_formatted LONGTEXT DEFAULT NULL, -- The formatted content of the entry before the update
+    updated_date DATETIME DEFAULT NULL, -- The updated date of the entry before the update
+    content_hash CHAR(64) DEFAULT NULL, -- The content hash of the entry before the update
+    revision_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- When the update was detected
+    FOREIGN KEY (rss_item_id) REFERENCES rss_items(id) ON DELETE CASCADE -- Link to rss_items table with ON DELETE CASCADE
+);
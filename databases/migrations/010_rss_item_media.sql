@@ -0,0 +1,14 @@
+-- Store the media files attached to entries (enclosures, media:content), with the iTunes details of podcast episodes.
+CREATE TABLE rss_item_media (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY, -- Unique identifier for each media file
+    rss_item_id INT UNSIGNED NOT NULL, -- Foreign key linking to the RSS entry the media is attached to
+    url TEXT NOT NULL, -- URL of the media file (enclosure or media:content)
+    mime_type VARCHAR(255) DEFAULT NULL, -- MIME type of the media file
+    medium VARCHAR(16) NOT NULL CHECK (medium IN ('audio', 'video', 'image', 'other')), -- Kind of media
+    length BIGINT UNSIGNED DEFAULT NULL, -- Size of the media file in bytes
+    duration INT UNSIGNED DEFAULT NULL, -- Duration of the media in seconds
+    episode INT UNSIGNED DEFAULT NULL, -- Episode number of a podcast episode
+    season INT UNSIGNED DEFAULT NULL, -- Season number of a podcast episode
+    artwork_url TEXT DEFAULT NULL, -- URL of the artwork of the media
+    FOREIGN KEY (rss_item_id) REFERENCES rss_items(id) ON DELETE CASCADE -- Link to rss_items table with ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+-- Keep the metadata advertised by each feed (title, description, website, image) and the favicon of its website.
+ALTER TABLE rss_feeds
+    ADD COLUMN title VARCHAR(255) DEFAULT NULL AFTER url, -- Title advertised by the feed, refreshed at every fetch
+    ADD COLUMN description TEXT DEFAULT NULL AFTER title, -- Description advertised by the feed, refreshed at every fetch
+    ADD COLUMN site_link TEXT DEFAULT NULL AFTER description, -- URL of the website of the feed
+    ADD COLUMN image_url TEXT DEFAULT NULL AFTER site_link, -- URL of the image or artwork of the feed
+    ADD COLUMN icon_url TEXT DEFAULT NULL AFTER image_url, -- URL of the favicon of the website of the feed
+    ADD COLUMN icon_checked_at TIMESTAMP DEFAULT NULL AFTER icon_url; -- Last time the favicon of the website was looked up
@@ -0,0 +1,5 @@
+-- Allow private feeds to be fetched with basic auth or custom headers.
+ALTER TABLE rss_feeds
+    ADD COLUMN auth_username VARCHAR(255) DEFAULT NULL AFTER fetch_full_text, -- User name sent with basic auth to fetch a private feed
+    ADD COLUMN auth_password VARCHAR(255) DEFAULT NULL AFTER auth_username, -- Password sent with basic auth to fetch a private feed
+    ADD COLUMN request_headers JSON DEFAULT NULL AFTER auth_password; -- Custom headers sent to fetch a private feed, as a JSON object of names to values
@@ -0,0 +1,14 @@
+-- Keep the WebSub subscriptions of the feeds whose hub pushes their updates.
+CREATE TABLE websub_subscriptions (
+    rss_feed_id SMALLINT UNSIGNED PRIMARY KEY, -- Foreign key linking to the subscribed RSS feed
+    hub_url VARCHAR(767) NOT NULL, -- URL of the WebSub hub advertised by the feed
+    topic_url VARCHAR(767) NOT NULL, -- URL of the topic, the self URL advertised by the feed
+    secret VARCHAR(64) NOT NULL, -- Secret used by the hub to sign the content it pushes
+    state VARCHAR(16) DEFAULT 'new' NOT NULL CONSTRAINT websub_subscriptions_state_check CHECK (state IN ('new', 'pending', 'active', 'denied', 'expired')), -- State of the subscription
+    lease_seconds INT UNSIGNED DEFAULT NULL, -- Duration of the lease granted by the hub, in seconds
+    lease_expires_at TIMESTAMP DEFAULT NULL, -- Time at which the lease lapses unless it is renewed
+    requested_at TIMESTAMP DEFAULT NULL, -- Last time the subscription was requested from the hub
+    last_push_at TIMESTAMP DEFAULT NULL, -- Last time the hub pushed content
+    last_error TEXT DEFAULT NULL, -- Error returned by the last subscription request, or reason of a denial
+    FOREIGN KEY (rss_feed_id) REFERENCES rss_feeds(id) ON DELETE CASCADE -- Link to rss_feeds table with ON DELETE CASCADE
+);
@@ -0,0 +1,18 @@
+-- Purge old entries according to a retention policy, global or per feed, except the starred and pinned ones.
+ALTER TABLE rss_feeds
+    ADD COLUMN retention_days SMALLINT UNSIGNED DEFAULT NULL AFTER request_headers, -- Number of days the entries are kept, 0 to keep them forever, NULL to use RETENTION_DAYS
+    ADD COLUMN retention_max_items INT UNSIGNED DEFAULT NULL AFTER retention_days; -- Number of most recent entries kept, 0 for no maximum, NULL to use RETENTION_MAX_ITEMS
+
+ALTER TABLE rss_items
+    ADD COLUMN is_starred BOOL DEFAULT FALSE NOT NULL AFTER is_hidden, -- Check if the article is starred or not, starred articles are never purged
+    ADD COLUMN is_pinned BOOL DEFAULT FALSE NOT NULL AFTER is_starred, -- Check if the article is pinned or not, pinned articles are never purged
+    ADD COLUMN archived_at TIMESTAMP DEFAULT NULL AFTER is_pinned; -- When the entry was archived by the retention policy, its content being dropped
+
+-- Keep the entries deleted by the retention policy from being ingested again.
+CREATE TABLE rss_item_tombstones (
+    rss_feed_id SMALLINT UNSIGNED NOT NULL, -- Foreign key linking to the RSS feed of the deleted entry
+    item_key CHAR(64) NOT NULL, -- Identity key of the deleted entry, see rss_items.item_key
+    deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- When the entry was deleted by the retention policy
+    PRIMARY KEY (rss_feed_id, item_key),
+    FOREIGN KEY (rss_feed_id) REFERENCES rss_feeds(id) ON DELETE CASCADE -- Link to rss_feeds table with ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+-- Record the repairs applied to the document of each feed before it could be parsed (charset transcoding,
+-- unescaped ampersands, control characters...), so that they can be listed with the `repaired-feeds` command.
+ALTER TABLE rss_feeds
+    ADD COLUMN last_repair TEXT DEFAULT NULL AFTER last_success_at, -- Repairs applied to the last fetched document, NULL if it was well-formed
+    ADD COLUMN last_repair_at TIMESTAMP DEFAULT NULL AFTER last_repair; -- Last time the document of the feed had to be repaired
@@ -0,0 +1,6 @@
+-- Count the failed cleanings of each entry, so that an entry failing too many times is given up on
+-- instead of being sent to the LLM at every cycle.
+ALTER TABLE rss_items
+    ADD COLUMN clean_attempts SMALLINT UNSIGNED DEFAULT 0 NOT NULL AFTER content_formatted, -- Number of cleaning cycles that failed for the entry
+    ADD COLUMN clean_error TEXT DEFAULT NULL AFTER clean_attempts, -- Error returned by the last failed cleaning of the entry
+    ADD COLUMN clean_failed_at TIMESTAMP DEFAULT NULL AFTER clean_error; -- When the entry was given up on after too many failed cleanings, it is no longer cleaned
@@ -0,0 +1,26 @@
+-- Track the cleaning state of each entry explicitly instead of guessing it from the length of content_formatted,
+-- and let several instances clean entries at the same time by claiming them.
+ALTER TABLE rss_items
+    ADD COLUMN clean_status VARCHAR(16) DEFAULT 'pending' NOT NULL CONSTRAINT rss_items_clean_status_check CHECK (clean_status IN ('pending', 'cleaning', 'cleaned', 'skipped', 'failed')) AFTER content_formatted, -- Cleaning state of the entry
+    ADD COLUMN clean_model VARCHAR(255) DEFAULT NULL AFTER clean_error, -- Model that cleaned the entry
+    ADD COLUMN clean_prompt_version VARCHAR(64) DEFAULT NULL AFTER clean_model, -- Version of the prompt the entry was cleaned with
+    ADD COLUMN clean_claimed_by VARCHAR(255) DEFAULT NULL AFTER clean_prompt_version, -- Instance cleaning the entry, while its state is 'cleaning'
+    ADD COLUMN clean_started_at TIMESTAMP DEFAULT NULL AFTER clean_claimed_by, -- When the last cleaning of the entry started
+    ADD COLUMN clean_finished_at TIMESTAMP DEFAULT NULL AFTER clean_started_at, -- When the last cleaning of the entry ended, successfully or not
+    ADD INDEX (clean_status);
+
+-- The entries cleaned so far were cleaned with the first version of the prompt.
+UPDATE rss_items
+SET clean_status = 'cleaned', clean_prompt_version = 'v1'
+WHERE content_formatted IS NOT NULL AND LENGTH(content_formatted) > 10;
+
+UPDATE rss_items
+SET clean_status = 'failed', clean_finished_at = clean_failed_at
+WHERE clean_status = 'pending' AND clean_failed_at IS NOT NULL;
+
+UPDATE rss_items
+SET clean_status = 'skipped'
+WHERE clean_status = 'pending' AND (content_raw IS NULL OR LENGTH(content_raw) <= 10);
+
+ALTER TABLE rss_items
+    DROP COLUMN clean_failed_at;
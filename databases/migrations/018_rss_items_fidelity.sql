@@ -0,0 +1,5 @@
+-- Flag the entries whose cleaned content may not be faithful to the original article (words added or dropped,
+-- missing paragraphs, truncation), so that they can be listed with the `items-to-review` command.
+ALTER TABLE rss_items
+    ADD COLUMN needs_review BOOL DEFAULT FALSE NOT NULL AFTER clean_prompt_version, -- Check if the cleaned content may not be faithful to the entry and should be reviewed
+    ADD COLUMN fidelity_issue TEXT DEFAULT NULL AFTER needs_review; -- Why the output of the model was found unfaithful to the entry, if it was
@@ -0,0 +1,4 @@
+-- Prune the tombstones of the entries their feed no longer publishes, so that the table does not grow forever.
+ALTER TABLE rss_item_tombstones
+    ADD COLUMN last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP AFTER deleted_at, -- When the feed last published the deleted entry
+    ADD INDEX (last_seen_at);
@@ -8,10 +8,38 @@ CREATE TABLE rss_feeds (
     common_name VARCHAR(255) NOT NULL, -- Common name for the RSS feed
     type VARCHAR(255) NOT NULL CHECK (LOWER(type) IN ('rss', 'atom', 'json', 'scrap', 'other')), -- Type of the feed with a check constraint
     url VARCHAR(767) NOT NULL, -- URL of the RSS feed
+    title VARCHAR(255) DEFAULT NULL, -- Title advertised by the feed, refreshed at every fetch
+    description TEXT DEFAULT NULL, -- Description advertised by the feed, refreshed at every fetch
+    site_link TEXT DEFAULT NULL, -- URL of the website of the feed
+    image_url TEXT DEFAULT NULL, -- URL of the image or artwork of the feed
+    icon_url TEXT DEFAULT NULL, -- URL of the favicon of the website of the feed
+    icon_checked_at TIMESTAMP DEFAULT NULL, -- Last time the favicon of the website was looked up
     categories JSON DEFAULT NULL, -- Categories/tags associated with the entry
+    source_categories JSON DEFAULT NULL, -- Categories advertised by the channel of the feed, normalized
+    scrap_config JSON DEFAULT NULL, -- CSS selectors used to scrape the page of a feed of type 'scrap'
+    fetch_full_text BOOL DEFAULT FALSE NOT NULL, -- Download the full article of each item instead of keeping the teaser of the feed
+    auth_username VARCHAR(255) DEFAULT NULL, -- User name sent with basic auth to fetch a private feed
+    auth_password VARCHAR(255) DEFAULT NULL, -- Password sent with basic auth to fetch a private feed
+    request_headers JSON DEFAULT NULL, -- Custom headers sent to fetch a private feed, as a JSON object of names to values
+    retention_days SMALLINT UNSIGNED DEFAULT NULL, -- Number of days the entries are kept, 0 to keep them forever, NULL to use RETENTION_DAYS
+    retention_max_items INT UNSIGNED DEFAULT NULL, -- Number of most recent entries kept, 0 for no maximum, NULL to use RETENTION_MAX_ITEMS
     last_update TIMESTAMP DEFAULT NULL, -- Last update time for the feed
+    etag VARCHAR(255) DEFAULT NULL, -- ETag response header returned by the last successful fetch
+    last_modified VARCHAR(64) DEFAULT NULL, -- Last-Modified response header returned by the last successful fetch
+    fetch_interval INT UNSIGNED DEFAULT NULL, -- Polling interval in minutes, computed from the publication cadence of the feed
+    next_fetch_at TIMESTAMP DEFAULT NULL, -- Time at which the feed should be fetched next
+    consecutive_failures SMALLINT UNSIGNED DEFAULT 0 NOT NULL, -- Number of fetches that failed in a row
+    status VARCHAR(16) DEFAULT 'active' NOT NULL CONSTRAINT rss_feeds_status_check CHECK (status IN ('active', 'suspended', 'retired')), -- Fetch status of the feed
+    last_error TEXT DEFAULT NULL, -- Error returned by the last failed fetch
+    last_http_status SMALLINT UNSIGNED DEFAULT NULL, -- HTTP status code returned by the last fetch
+    last_success_at TIMESTAMP DEFAULT NULL, -- Last time the feed was fetched successfully
+    last_repair TEXT DEFAULT NULL, -- Repairs applied to the last fetched document, NULL if it was well-formed
+    last_repair_at TIMESTAMP DEFAULT NULL, -- Last time the document of the feed had to be repaired
+    redirect_url VARCHAR(767) DEFAULT NULL, -- URL the feed was permanently redirected to at the last fetches
+    redirect_count SMALLINT UNSIGNED DEFAULT 0 NOT NULL, -- Number of fetches in a row redirected to redirect_url
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE, -- Foreign key linking to the users table with ON DELETE CASCADE
-    UNIQUE (user_id, url)
+    UNIQUE (user_id, url),
+    INDEX (next_fetch_at)
 );
 
 -- Drop the table if it already exists to avoid conflicts
@@ -28,12 +56,96 @@ CREATE TABLE rss_items (
     summary_formatted TEXT DEFAULT NULL, -- A brief summary or description of the entry
     content_raw LONGTEXT DEFAULT NULL, -- Full content of the entry
     content_formatted LONGTEXT DEFAULT NULL, -- Full content of the entry
-    categories JSON DEFAULT NULL, -- Categories/tags associated with the entry
-    guid VARCHAR(767) UNIQUE DEFAULT NULL, -- A unique identifier for the entry (GUID in RSS/ATOM)
+    clean_status VARCHAR(16) DEFAULT 'pending' NOT NULL CONSTRAINT rss_items_clean_status_check CHECK (clean_status IN ('pending', 'cleaning', 'cleaned', 'skipped', 'failed')), -- Cleaning state of the entry
+    clean_attempts SMALLINT UNSIGNED DEFAULT 0 NOT NULL, -- Number of cleaning cycles that failed for the entry
+    clean_error TEXT DEFAULT NULL, -- Error returned by the last failed cleaning of the entry
+    clean_model VARCHAR(255) DEFAULT NULL, -- Model that cleaned the entry
+    clean_prompt_version VARCHAR(64) DEFAULT NULL, -- Version of the prompt the entry was cleaned with
+    needs_review BOOL DEFAULT FALSE NOT NULL, -- Check if the cleaned content may not be faithful to the entry and should be reviewed
+    fidelity_issue TEXT DEFAULT NULL, -- Why the output of the model was found unfaithful to the entry, if it was
+    clean_claimed_by VARCHAR(255) DEFAULT NULL, -- Instance cleaning the entry, while its state is 'cleaning'
+    clean_started_at TIMESTAMP DEFAULT NULL, -- When the last cleaning of the entry started
+    clean_finished_at TIMESTAMP DEFAULT NULL, -- When the last cleaning of the entry ended, successfully or not
+    content_hash CHAR(64) DEFAULT NULL, -- SHA-256 of the title, summary and content of the entry as published by the feed, used to detect updates
+    categories JSON DEFAULT NULL, -- Categories/tags associated with the entry, normalized (trimmed, lower-cased, deduplicated)
+    guid VARCHAR(767) DEFAULT NULL, -- The identifier of the entry given by the feed (GUID in RSS/ATOM)
+    item_key CHAR(64) NOT NULL, -- SHA-256 of the GUID, or of the link, or of the title and publication date, identifying the entry within its feed
     published_date DATETIME DEFAULT CURRENT_TIMESTAMP, -- The publication date of the entry
     updated_date DATETIME DEFAULT NULL, -- The updated date of the entry (for ATOM feeds)
     extraction_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- When the entry was extracted
     is_read BOOL DEFAULT FALSE NOT NULL, -- Check if the article is read or not
     is_hidden BOOL DEFAULT FALSE NOT NULL, -- Check if the article is hidden or not 
+    is_starred BOOL DEFAULT FALSE NOT NULL, -- Check if the article is starred or not, starred articles are never purged
+    is_pinned BOOL DEFAULT FALSE NOT NULL, -- Check if the article is pinned or not, pinned articles are never purged
+    archived_at TIMESTAMP DEFAULT NULL, -- When the entry was archived by the retention policy, its content being dropped
+    FOREIGN KEY (rss_feed_id) REFERENCES rss_feeds(id) ON DELETE CASCADE, -- Link to rss_feeds table with ON DELETE CASCADE
+    UNIQUE (rss_feed_id, item_key), -- An entry is unique within its feed, so that several users can subscribe to the same feed
+    INDEX (clean_status)
+);
+
+-- Drop the table if it already exists to avoid conflicts
+-- DROP TABLE IF EXISTS rss_item_revisions;
+
+-- Create the rss_item_revisions table
+CREATE TABLE rss_item_revisions (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY, -- Unique identifier for each revision
+    rss_item_id INT UNSIGNED NOT NULL, -- Foreign key linking to the revised RSS entry
+    title VARCHAR(255) NOT NULL, -- The title of the entry before the update
+    summary_raw TEXT DEFAULT NULL, -- The summary of the entry before the update
+    content_raw LONGTEXT DEFAULT NULL, -- The content of the entry before the update
+    content_formatted LONGTEXT DEFAULT NULL, -- The formatted content of the entry before the update
+    updated_date DATETIME DEFAULT NULL, -- The updated date of the entry before the update
+    content_hash CHAR(64) DEFAULT NULL, -- The content hash of the entry before the update
+    revision_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- When the update was detected
+    FOREIGN KEY (rss_item_id) REFERENCES rss_items(id) ON DELETE CASCADE -- Link to rss_items table with ON DELETE CASCADE
+);
+
+-- Drop the table if it already exists to avoid conflicts
+-- DROP TABLE IF EXISTS rss_item_media;
+
+-- Create the rss_item_media table
+CREATE TABLE rss_item_media (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY, -- Unique identifier for each media file
+    rss_item_id INT UNSIGNED NOT NULL, -- Foreign key linking to the RSS entry the media is attached to
+    url TEXT NOT NULL, -- URL of the media file (enclosure or media:content)
+    mime_type VARCHAR(255) DEFAULT NULL, -- MIME type of the media file
+    medium VARCHAR(16) NOT NULL CHECK (medium IN ('audio', 'video', 'image', 'other')), -- Kind of media
+    length BIGINT UNSIGNED DEFAULT NULL, -- Size of the media file in bytes
+    duration INT UNSIGNED DEFAULT NULL, -- Duration of the media in seconds
+    episode INT UNSIGNED DEFAULT NULL, -- Episode number of a podcast episode
+    season INT UNSIGNED DEFAULT NULL, -- Season number of a podcast episode
+    artwork_url TEXT DEFAULT NULL, -- URL of the artwork of the media
+    FOREIGN KEY (rss_item_id) REFERENCES rss_items(id) ON DELETE CASCADE -- Link to rss_items table with ON DELETE CASCADE
+);
+
+-- Drop the table if it already exists to avoid conflicts
+-- DROP TABLE IF EXISTS websub_subscriptions;
+
+-- Create the websub_subscriptions table
+CREATE TABLE websub_subscriptions (
+    rss_feed_id SMALLINT UNSIGNED PRIMARY KEY, -- Foreign key linking to the subscribed RSS feed
+    hub_url VARCHAR(767) NOT NULL, -- URL of the WebSub hub advertised by the feed
+    topic_url VARCHAR(767) NOT NULL, -- URL of the topic, the self URL advertised by the feed
+    secret VARCHAR(64) NOT NULL, -- Secret used by the hub to sign the content it pushes
+    state VARCHAR(16) DEFAULT 'new' NOT NULL CONSTRAINT websub_subscriptions_state_check CHECK (state IN ('new', 'pending', 'active', 'denied', 'expired')), -- State of the subscription
+    lease_seconds INT UNSIGNED DEFAULT NULL, -- Duration of the lease granted by the hub, in seconds
+    lease_expires_at TIMESTAMP DEFAULT NULL, -- Time at which the lease lapses unless it is renewed
+    requested_at TIMESTAMP DEFAULT NULL, -- Last time the subscription was requested from the hub
+    last_push_at TIMESTAMP DEFAULT NULL, -- Last time the hub pushed content
+    last_error TEXT DEFAULT NULL, -- Error returned by the last subscription request, or reason of a denial
+    FOREIGN KEY (rss_feed_id) REFERENCES rss_feeds(id) ON DELETE CASCADE -- Link to rss_feeds table with ON DELETE CASCADE
+);
+
+-- Drop the table if it already exists to avoid conflicts
+-- DROP TABLE IF EXISTS rss_item_tombstones;
+
+-- Create the rss_item_tombstones table, which keeps the entries deleted by the retention policy from being ingested again
+CREATE TABLE rss_item_tombstones (
+    rss_feed_id SMALLINT UNSIGNED NOT NULL, -- Foreign key linking to the RSS feed of the deleted entry
+    item_key CHAR(64) NOT NULL, -- Identity key of the deleted entry, see rss_items.item_key
+    deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- When the entry was deleted by the retention policy
+    last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- When the feed last published the deleted entry
+    PRIMARY KEY (rss_feed_id, item_key),
+    INDEX (last_seen_at),
     FOREIGN KEY (rss_feed_id) REFERENCES rss_feeds(id) ON DELETE CASCADE -- Link to rss_feeds table with ON DELETE CASCADE
 );
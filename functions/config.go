@@ -0,0 +1,81 @@
+package functions
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	gowebly "github.com/gowebly/helpers"
+)
+
+// getenvInt retrieves an environment variable and converts it to a positive integer.
+// It returns the fallback value if the variable is not set or cannot be casted to a positive integer.
+//
+// Parameters:
+//   - key: The name of the environment variable.
+//   - fallback: The value to return if the variable is not set or invalid.
+//
+// Returns:
+//   - int: The value of the environment variable, or the fallback value.
+func getenvInt(key string, fallback int) int {
+	strValue := strings.TrimSpace(gowebly.Getenv(key, ""))
+	if strValue == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(strValue)
+	if err != nil || value < 1 {
+		slog.Warn("Invalid environment variable, using the default value", "Variable", key, "Value", strValue, "Default", fallback)
+		return fallback
+	}
+
+	return value
+}
+
+// getenvNonNegativeInt retrieves an environment variable and converts it to a positive integer or zero, for the settings
+// where 0 disables a feature. It returns the fallback value if the variable is not set or cannot be casted to such an integer.
+//
+// Parameters:
+//   - key: The name of the environment variable.
+//   - fallback: The value to return if the variable is not set or invalid.
+//
+// Returns:
+//   - int: The value of the environment variable, or the fallback value.
+func getenvNonNegativeInt(key string, fallback int) int {
+	strValue := strings.TrimSpace(gowebly.Getenv(key, ""))
+	if strValue == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(strValue)
+	if err != nil || value < 0 {
+		slog.Warn("Invalid environment variable, using the default value", "Variable", key, "Value", strValue, "Default", fallback)
+		return fallback
+	}
+
+	return value
+}
+
+// getenvBool retrieves an environment variable and converts it to a boolean (1, t, true, 0, f, false...).
+// It returns the fallback value if the variable is not set or cannot be casted to a boolean.
+//
+// Parameters:
+//   - key: The name of the environment variable.
+//   - fallback: The value to return if the variable is not set or invalid.
+//
+// Returns:
+//   - bool: The value of the environment variable, or the fallback value.
+func getenvBool(key string, fallback bool) bool {
+	strValue := strings.TrimSpace(gowebly.Getenv(key, ""))
+	if strValue == "" {
+		return fallback
+	}
+
+	value, err := strconv.ParseBool(strValue)
+	if err != nil {
+		slog.Warn("Invalid environment variable, using the default value", "Variable", key, "Value", strValue, "Default", fallback)
+		return fallback
+	}
+
+	return value
+}
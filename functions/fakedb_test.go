@@ -0,0 +1,144 @@
+package functions
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStatement is a statement run against a fakeDB, with its arguments.
+type fakeStatement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// fakeResult holds the rows a fakeDB answers to the queries containing a given string.
+type fakeResult struct {
+	Contains string
+	Columns  []string
+	Rows     [][]driver.Value
+}
+
+// fakeDB is an in-memory database/sql driver answering the queries with canned rows and recording every statement,
+// so that the functions writing to the database can be tested without a MySQL server.
+type fakeDB struct {
+	mu         sync.Mutex
+	results    []fakeResult    // The rows answered to the queries, the first matching wins, no row otherwise.
+	statements []fakeStatement // The statements run, in order.
+}
+
+// newFakeDB opens a database connection backed by a fakeDB, closed at the end of the test.
+func newFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db, fake
+}
+
+// answer sets the rows answered to the queries containing the given string, unless an earlier answer matches them.
+func (f *fakeDB) answer(contains string, columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, fakeResult{Contains: contains, Columns: columns, Rows: rows})
+}
+
+// executed returns the statements run whose query contains the given string.
+func (f *fakeDB) executed(contains string) []fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var statements []fakeStatement
+	for _, statement := range f.statements {
+		if strings.Contains(statement.Query, contains) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func (f *fakeDB) record(query string, args []driver.NamedValue) fakeResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.statements = append(f.statements, fakeStatement{Query: query, Args: values})
+	for _, result := range f.results {
+		if strings.Contains(query, result.Contains) {
+			return result
+		}
+	}
+	return fakeResult{}
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.record(query, args)
+	return &fakeRows{columns: result.Columns, rows: result.Rows}, nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return fakeConn{s.db}.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeConn{s.db}.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
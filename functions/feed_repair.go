@@ -0,0 +1,271 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cl3mcg/speakrine/databases"
+	"github.com/cl3mcg/speakrine/types"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+var (
+	// xmlDeclarationEncoding matches the encoding attribute of the XML declaration at the start of a document.
+	xmlDeclarationEncoding = regexp.MustCompile(`^<\?xml[^>]*?\sencoding\s*=\s*["']([^"']*)["']`)
+	// xmlEntityReference matches the character and entity references that may follow an ampersand.
+	xmlEntityReference = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z_][A-Za-z0-9._-]*);`)
+)
+
+// utf8BOM is the byte order mark some publishers put at the start of UTF-8 documents.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// declaredEncoding returns the encoding declared by the XML declaration of a document, or an empty string.
+func declaredEncoding(body []byte) string {
+	match := xmlDeclarationEncoding.FindSubmatch(body[:min(len(body), 512)])
+	if match == nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(string(match[1])))
+}
+
+// setDeclaredEncoding rewrites the encoding of the XML declaration of a document to UTF-8, once it has been transcoded.
+func setDeclaredEncoding(body []byte) []byte {
+	loc := xmlDeclarationEncoding.FindSubmatchIndex(body[:min(len(body), 512)])
+	if loc == nil {
+		return body
+	}
+	return append(append(append([]byte{}, body[:loc[2]]...), "UTF-8"...), body[loc[3]:]...)
+}
+
+// isUTF8Label reports whether an encoding label denotes UTF-8.
+func isUTF8Label(label string) bool {
+	return label == "" || label == "utf-8" || label == "utf8" || label == "unicode-1-1-utf-8"
+}
+
+// transcodeFeedBody converts a feed document to UTF-8. The encoding declared by the document, then the charset of the
+// Content-Type header, are trusted as long as the document is not valid UTF-8. A document declared as UTF-8 that is not
+// valid UTF-8 is decoded as Windows-1252, the most common mislabeled encoding.
+//
+// Parameters:
+//   - body: The feed document.
+//   - contentType: The Content-Type header of the response, or an empty string.
+//
+// Returns:
+//   - []byte: The document encoded in UTF-8, with its XML declaration updated.
+//   - string: A description of the repair applied, or an empty string if the document was correctly labeled.
+func transcodeFeedBody(body []byte, contentType string) ([]byte, string) {
+	label := declaredEncoding(body)
+	if label == "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			label = strings.ToLower(strings.TrimSpace(params["charset"]))
+		}
+	}
+
+	if utf8.Valid(body) {
+		repair := ""
+		if !isUTF8Label(label) && label != "us-ascii" && bytes.ContainsFunc(body, func(r rune) bool { return r >= utf8.RuneSelf }) {
+			repair = fmt.Sprintf("declared as %v but encoded in UTF-8", label)
+		}
+		if isUTF8Label(label) {
+			return body, repair
+		}
+		return setDeclaredEncoding(body), repair
+	}
+
+	var decoder *encoding.Decoder
+	repair := ""
+	if isUTF8Label(label) {
+		decoder = charmap.Windows1252.NewDecoder()
+		repair = "invalid UTF-8, transcoded from windows-1252"
+	} else if enc, err := htmlindex.Get(label); err == nil {
+		decoder = enc.NewDecoder()
+	} else {
+		decoder = charmap.Windows1252.NewDecoder()
+		repair = fmt.Sprintf("unknown encoding %q, transcoded from windows-1252", label)
+	}
+
+	decoded, err := decoder.Bytes(body)
+	if err != nil {
+		return body, ""
+	}
+	return setDeclaredEncoding(decoded), repair
+}
+
+// stripInvalidXMLChars removes the control characters that are not allowed in XML 1.0 documents.
+func stripInvalidXMLChars(body []byte) ([]byte, int) {
+	count := 0
+	cleaned := bytes.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0xFFFE || r == 0xFFFF {
+			count++
+			return -1
+		}
+		return r
+	}, body)
+	return cleaned, count
+}
+
+// escapeBareAmpersands escapes the ampersands that do not start a character or entity reference,
+// leaving the content of CDATA sections and comments alone.
+func escapeBareAmpersands(body []byte) ([]byte, int) {
+	var buf bytes.Buffer
+	buf.Grow(len(body))
+	count := 0
+	for i := 0; i < len(body); {
+		switch {
+		case bytes.HasPrefix(body[i:], []byte("<![CDATA[")):
+			end := bytes.Index(body[i:], []byte("]]>"))
+			if end < 0 {
+				end = len(body) - i - 3
+			}
+			buf.Write(body[i : i+end+3])
+			i += end + 3
+		case bytes.HasPrefix(body[i:], []byte("<!--")):
+			end := bytes.Index(body[i:], []byte("-->"))
+			if end < 0 {
+				end = len(body) - i - 3
+			}
+			buf.Write(body[i : i+end+3])
+			i += end + 3
+		case body[i] == '&' && !xmlEntityReference.Match(body[i:min(len(body), i+64)]):
+			buf.WriteString("&amp;")
+			count++
+			i++
+		default:
+			buf.WriteByte(body[i])
+			i++
+		}
+	}
+	return buf.Bytes(), count
+}
+
+// normalizeFeedBody prepares a feed document for parsing: it removes the byte order mark and the leading blanks,
+// transcodes the document to UTF-8, and repairs the common XML breakage (control characters, unescaped ampersands).
+//
+// Parameters:
+//   - body: The feed document.
+//   - contentType: The Content-Type header of the response, or an empty string.
+//
+// Returns:
+//   - []byte: The normalized document.
+//   - []string: The descriptions of the repairs applied, empty if the document was well-formed.
+func normalizeFeedBody(body []byte, contentType string) ([]byte, []string) {
+	var repairs []string
+
+	// UTF-16 documents carry a byte order mark, decode them first.
+	if bytes.HasPrefix(body, []byte{0xFF, 0xFE}) || bytes.HasPrefix(body, []byte{0xFE, 0xFF}) {
+		if decoded, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(body); err == nil {
+			body = setDeclaredEncoding(decoded)
+		}
+	}
+	if bytes.HasPrefix(body, utf8BOM) {
+		body = body[len(utf8BOM):]
+		repairs = append(repairs, "removed byte order mark")
+	}
+	if trimmed := bytes.TrimLeft(body, " \t\r\n"); len(trimmed) != len(body) {
+		body = trimmed
+		repairs = append(repairs, "removed leading blanks")
+	}
+
+	body, repair := transcodeFeedBody(body, contentType)
+	if repair != "" {
+		repairs = append(repairs, repair)
+	}
+
+	// JSON feeds only need to be valid UTF-8.
+	if !bytes.HasPrefix(body, []byte("<")) {
+		return body, repairs
+	}
+
+	body, count := stripInvalidXMLChars(body)
+	if count > 0 {
+		repairs = append(repairs, fmt.Sprintf("removed %d control characters", count))
+	}
+	body, count = escapeBareAmpersands(body)
+	if count > 0 {
+		repairs = append(repairs, fmt.Sprintf("escaped %d bare ampersands", count))
+	}
+	return body, repairs
+}
+
+// parseFeedBody normalizes and parses a feed document.
+//
+// Parameters:
+//   - body: The feed document.
+//   - contentType: The Content-Type header of the response, or an empty string.
+//
+// Returns:
+//   - *gofeed.Feed: The parsed feed.
+//   - []string: The descriptions of the repairs applied before parsing.
+//   - error: An error, if the document cannot be parsed even after repair.
+func parseFeedBody(body []byte, contentType string) (*gofeed.Feed, []string, error) {
+	body, repairs := normalizeFeedBody(body, contentType)
+	feed, err := newFeedParser().Parse(bytes.NewReader(body))
+	return feed, repairs, err
+}
+
+// updateFeedRepairs records the repairs applied to the last fetched document of a feed, so that its publisher can be told.
+// The repairs are cleared once the feed is served well-formed.
+//
+// Parameters:
+//   - exec: The database connection instance or transaction.
+//   - feedId: The ID of the feed.
+//   - repairs: The descriptions of the repairs applied, empty if the document was well-formed.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the feed.
+func updateFeedRepairs(exec sqlExecutor, feedId int, repairs []string) error {
+	if len(repairs) == 0 {
+		_, err := exec.Exec("UPDATE rss_feeds SET last_repair = NULL WHERE id = ?", feedId)
+		return err
+	}
+	_, err := exec.Exec("UPDATE rss_feeds SET last_repair = ?, last_repair_at = NOW() WHERE id = ?", strings.Join(repairs, "; "), feedId)
+	return err
+}
+
+// ListRepairedFeeds retrieves the feeds whose last fetched document had to be repaired before it could be parsed.
+//
+// Returns:
+//   - []types.RssFeed: The repaired feeds, with the repairs applied in LastRepair.
+//   - error: An error, if any, occurred while querying the database.
+func ListRepairedFeeds() ([]types.RssFeed, error) {
+	db := databases.GetDB()
+
+	rows, err := db.Query("SELECT id, common_name, url, last_repair, last_repair_at FROM rss_feeds WHERE last_repair IS NOT NULL ORDER BY last_repair_at DESC")
+	if err != nil {
+		slog.Error("Error querying repaired rss_feeds", "Error", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	var feeds []types.RssFeed
+	for rows.Next() {
+		var feed types.RssFeed
+		var repairedAt sql.NullTime
+		if err := rows.Scan(&feed.Id, &feed.CommonName, &feed.Url, &feed.LastRepair, &repairedAt); err != nil {
+			slog.Error("Error scanning rss_feeds row", "Error", err)
+			return nil, err
+		}
+		feed.LastRepairAt = repairedAt.Time
+		feeds = append(feeds, feed)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over rss_feeds rows", "Error", err)
+		return nil, err
+	}
+	return feeds, nil
+}
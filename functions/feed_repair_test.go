@@ -0,0 +1,170 @@
+package functions
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestTranscodeFeedBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        string
+		wantRepair  string
+	}{
+		{
+			name: "utf-8 declared as utf-8",
+			body: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+			want: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+		},
+		{
+			name:       "utf-8 declared as latin-1",
+			body:       `<?xml version="1.0" encoding="ISO-8859-1"?><rss>café</rss>`,
+			want:       `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+			wantRepair: "declared as iso-8859-1 but encoded in UTF-8",
+		},
+		{
+			name: "ascii declared as latin-1",
+			body: `<?xml version="1.0" encoding="ISO-8859-1"?><rss>cafe</rss>`,
+			want: `<?xml version="1.0" encoding="UTF-8"?><rss>cafe</rss>`,
+		},
+		{
+			name: "latin-1 declared as latin-1",
+			body: "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss>caf\xe9</rss>",
+			want: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+		},
+		{
+			name:        "latin-1 declared by the Content-Type header",
+			body:        "<rss>caf\xe9</rss>",
+			contentType: "application/rss+xml; charset=ISO-8859-1",
+			want:        "<rss>café</rss>",
+		},
+		{
+			name:       "invalid utf-8 declared as utf-8",
+			body:       "<?xml version=\"1.0\" encoding=\"UTF-8\"?><rss>caf\xe9 \x93quoted\x94</rss>",
+			want:       `<?xml version="1.0" encoding="UTF-8"?><rss>café “quoted”</rss>`,
+			wantRepair: "invalid UTF-8, transcoded from windows-1252",
+		},
+		{
+			name:       "invalid utf-8 without declaration",
+			body:       "<rss>caf\xe9</rss>",
+			want:       "<rss>café</rss>",
+			wantRepair: "invalid UTF-8, transcoded from windows-1252",
+		},
+		{
+			name:       "unknown encoding",
+			body:       "<?xml version=\"1.0\" encoding=\"x-unknown\"?><rss>caf\xe9</rss>",
+			want:       `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+			wantRepair: `unknown encoding "x-unknown", transcoded from windows-1252`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, repair := transcodeFeedBody([]byte(tt.body), tt.contentType)
+			if string(got) != tt.want {
+				t.Errorf("transcodeFeedBody() body = %q, want %q", got, tt.want)
+			}
+			if repair != tt.wantRepair {
+				t.Errorf("transcodeFeedBody() repair = %q, want %q", repair, tt.wantRepair)
+			}
+		})
+	}
+}
+
+func TestEscapeBareAmpersands(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		want      string
+		wantCount int
+	}{
+		{"bare ampersand", "<title>Salt & pepper</title>", "<title>Salt &amp; pepper</title>", 1},
+		{"references", "<title>&amp; &lt; &#169; &#xA9; &custom.entity;</title>", "<title>&amp; &lt; &#169; &#xA9; &custom.entity;</title>", 0},
+		{"unterminated reference", "<link>https://example.com/?a=1&b=2</link>", "<link>https://example.com/?a=1&amp;b=2</link>", 1},
+		{"ampersand at the end", "Salt &", "Salt &amp;", 1},
+		{"inside cdata", "<description><![CDATA[Salt & pepper]]></description>", "<description><![CDATA[Salt & pepper]]></description>", 0},
+		{"inside and outside cdata", "<title>A & B</title><description><![CDATA[C & D]]> & E</description>", "<title>A &amp; B</title><description><![CDATA[C & D]]> &amp; E</description>", 2},
+		{"inside a comment", "<!-- Salt & pepper --><title>A & B</title>", "<!-- Salt & pepper --><title>A &amp; B</title>", 1},
+		{"unterminated cdata", "<title>A & B</title><description><![CDATA[C & D", "<title>A &amp; B</title><description><![CDATA[C & D", 1},
+		{"unterminated comment", "<title>A & B</title><!-- C & D", "<title>A &amp; B</title><!-- C & D", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count := escapeBareAmpersands([]byte(tt.body))
+			if string(got) != tt.want || count != tt.wantCount {
+				t.Errorf("escapeBareAmpersands() = %q, %d, want %q, %d", got, count, tt.want, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestNormalizeFeedBody(t *testing.T) {
+	utf16Body, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(`<?xml version="1.0" encoding="UTF-16"?><rss>café</rss>`)
+	if err != nil {
+		t.Fatalf("encoding the UTF-16 document: %v", err)
+	}
+	utf16BEBody, err := unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewEncoder().String(`<?xml version="1.0" encoding="UTF-16"?><rss>café</rss>`)
+	if err != nil {
+		t.Fatalf("encoding the UTF-16 document: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		want        string
+		wantRepairs []string
+	}{
+		{
+			name: "well-formed",
+			body: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+			want: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+		},
+		{
+			name: "utf-16 little endian with a byte order mark",
+			body: utf16Body,
+			want: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+		},
+		{
+			name: "utf-16 big endian with a byte order mark",
+			body: utf16BEBody,
+			want: `<?xml version="1.0" encoding="UTF-8"?><rss>café</rss>`,
+		},
+		{
+			name:        "utf-8 byte order mark and leading blanks",
+			body:        "\xef\xbb\xbf \n<rss>café</rss>",
+			want:        "<rss>café</rss>",
+			wantRepairs: []string{"removed byte order mark", "removed leading blanks"},
+		},
+		{
+			name:        "control characters and bare ampersands",
+			body:        "<rss><title>A & B\x01</title><description><![CDATA[C & D]]></description></rss>",
+			want:        "<rss><title>A &amp; B</title><description><![CDATA[C & D]]></description></rss>",
+			wantRepairs: []string{"removed 1 control characters", "escaped 1 bare ampersands"},
+		},
+		{
+			name:        "invalid utf-8",
+			body:        "<rss><title>caf\xe9</title></rss>",
+			want:        "<rss><title>café</title></rss>",
+			wantRepairs: []string{"invalid UTF-8, transcoded from windows-1252"},
+		},
+		{
+			name: "json feed",
+			body: `{"version": "https://jsonfeed.org/version/1.1", "title": "A & B"}`,
+			want: `{"version": "https://jsonfeed.org/version/1.1", "title": "A & B"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, repairs := normalizeFeedBody([]byte(tt.body), "")
+			if string(got) != tt.want {
+				t.Errorf("normalizeFeedBody() body = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(repairs, tt.wantRepairs) {
+				t.Errorf("normalizeFeedBody() repairs = %q, want %q", repairs, tt.wantRepairs)
+			}
+		})
+	}
+}
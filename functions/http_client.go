@@ -0,0 +1,172 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	gowebly "github.com/gowebly/helpers"
+)
+
+// speakrineUserAgent is the default User-Agent header sent with every HTTP request issued by the fetcher.
+const speakrineUserAgent = "Speakrine/1.0 (+https://github.com/cl3mcg/speakrine)"
+
+// httpConfig holds the settings shared by every HTTP request issued by the fetcher.
+type httpConfig struct {
+	Timeout     time.Duration // The maximum duration of a request, including reading the body.
+	MaxBodySize int64         // The maximum number of bytes read from a response body.
+	UserAgent   string        // The User-Agent header sent with every request.
+	Proxy       *url.URL      // The HTTP, HTTPS or SOCKS5 proxy requests go through, nil to use the proxy of the environment.
+}
+
+var (
+	httpClientsOnce sync.Once
+	sharedConfig    httpConfig
+	feedClient      *http.Client
+	pageClient      *http.Client
+	robotsClient    *http.Client
+)
+
+// loadHTTPConfig reads the HTTP settings from the FETCH_TIMEOUT, FETCH_MAX_BODY_SIZE, FETCH_USER_AGENT and FETCH_PROXY
+// environment variables. An invalid proxy URL is ignored.
+func loadHTTPConfig() httpConfig {
+	config := httpConfig{
+		Timeout:     time.Duration(getenvInt("FETCH_TIMEOUT", 30)) * time.Second,
+		MaxBodySize: int64(getenvInt("FETCH_MAX_BODY_SIZE", 10<<20)),
+		UserAgent:   strings.TrimSpace(gowebly.Getenv("FETCH_USER_AGENT", "")),
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = speakrineUserAgent
+	}
+
+	if rawProxy := strings.TrimSpace(gowebly.Getenv("FETCH_PROXY", "")); rawProxy != "" {
+		proxyUrl, err := url.Parse(rawProxy)
+		switch {
+		case err != nil:
+			slog.Warn("Ignoring invalid FETCH_PROXY", "Error", err)
+		case proxyUrl.Scheme != "http" && proxyUrl.Scheme != "https" && proxyUrl.Scheme != "socks5":
+			slog.Warn("Ignoring FETCH_PROXY with an unsupported scheme", "Scheme", proxyUrl.Scheme)
+		default:
+			config.Proxy = proxyUrl
+		}
+	}
+	return config
+}
+
+// initHTTPClients builds the HTTP clients from the environment the first time they are needed.
+// The clients share the same transport, so that connections are pooled across feeds and web pages.
+func initHTTPClients() {
+	httpClientsOnce.Do(func() {
+		sharedConfig = loadHTTPConfig()
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+		if sharedConfig.Proxy != nil {
+			transport.Proxy = http.ProxyURL(sharedConfig.Proxy)
+		}
+
+		// The feed client does not follow redirects by itself, so that the fetcher can tell permanent redirects from temporary ones.
+		feedClient = &http.Client{
+			Transport: transport,
+			Timeout:   sharedConfig.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		// The page client follows redirects, as long as the robots.txt file of their target allows them,
+		// and spaces them out like any other request.
+		pageClient = &http.Client{
+			Transport: transport,
+			Timeout:   sharedConfig.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxFeedRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxFeedRedirects)
+				}
+				if req.URL.Path != "/robots.txt" {
+					if err := checkRobots(req.Context(), req.URL); err != nil {
+						return err
+					}
+				}
+				return throttle.wait(req.Context(), feedHost(req.URL.String()))
+			},
+		}
+		// The robots.txt client follows redirects without checking them against robots.txt, since the policy of the host
+		// is what it is downloading: checking it would wait for the download in progress.
+		robotsClient = &http.Client{
+			Transport: transport,
+			Timeout:   sharedConfig.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxFeedRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxFeedRedirects)
+				}
+				return throttle.wait(req.Context(), feedHost(req.URL.String()))
+			},
+		}
+	})
+}
+
+// feedHTTPClient returns the HTTP client used to fetch feeds, which does not follow redirects.
+func feedHTTPClient() *http.Client {
+	initHTTPClients()
+	return feedClient
+}
+
+// pageHTTPClient returns the HTTP client used to download web pages, which follows redirects.
+func pageHTTPClient() *http.Client {
+	initHTTPClients()
+	return pageClient
+}
+
+// robotsHTTPClient returns the HTTP client used to download robots.txt files, which follows redirects.
+func robotsHTTPClient() *http.Client {
+	initHTTPClients()
+	return robotsClient
+}
+
+// httpSettings returns the HTTP settings shared by every request.
+func httpSettings() httpConfig {
+	initHTTPClients()
+	return sharedConfig
+}
+
+// feedCredentials holds the optional credentials of a private feed, stored in the rss_feeds table.
+type feedCredentials struct {
+	Username string            // The basic auth user name, basic auth is used when it is not empty.
+	Password string            // The basic auth password.
+	Headers  map[string]string // Custom headers sent with every request, such as an API token.
+}
+
+// parseFeedHeaders decodes the custom headers of a feed, stored as a JSON object in rss_feeds.request_headers.
+func parseFeedHeaders(rawHeaders string) (map[string]string, error) {
+	if strings.TrimSpace(rawHeaders) == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+		return nil, fmt.Errorf("invalid request_headers: %w", err)
+	}
+	return headers, nil
+}
+
+// apply adds the credentials to a request. They are only sent to the host of the feed,
+// so that they do not leak when the feed redirects to another host.
+//
+// Parameters:
+//   - req: The request to authenticate.
+//   - feedHost: The lower-cased host name of the feed.
+func (c feedCredentials) apply(req *http.Request, feedHost string) {
+	if !strings.EqualFold(req.URL.Hostname(), feedHost) {
+		return
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
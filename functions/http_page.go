@@ -0,0 +1,84 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/net/html/charset"
+)
+
+// fetchPage downloads the web page at the given URL with the shared page client.
+// The page is only downloaded if the robots.txt file of its host allows it, and the requests to its host are spaced out
+// by the host throttle.
+//
+// Parameters:
+//   - ctx: The context of the request, cancelling it aborts the download.
+//   - url: The URL of the page to download.
+//
+// Returns:
+//   - []byte: The body of the page, truncated to FETCH_MAX_BODY_SIZE bytes.
+//   - string: The URL of the page after following redirects.
+//   - error: An error, if any, occurred while downloading the page or if the server answered with an error status.
+func fetchPage(ctx context.Context, url string) ([]byte, string, error) {
+	body, finalUrl, _, err := downloadPage(ctx, url)
+	return body, finalUrl, err
+}
+
+// fetchHTMLPage downloads the web page at the given URL like fetchPage, and decodes it to UTF-8 from the charset of its
+// Content-Type header or of its <meta> tags, so that pages in Latin-1 or Windows-1252 are parsed correctly.
+//
+// Parameters:
+//   - ctx: The context of the request, cancelling it aborts the download.
+//   - url: The URL of the page to download.
+//
+// Returns:
+//   - []byte: The body of the page encoded in UTF-8, truncated to FETCH_MAX_BODY_SIZE bytes before decoding.
+//   - string: The URL of the page after following redirects.
+//   - error: An error, if any, occurred while downloading or decoding the page or if the server answered with an error status.
+func fetchHTMLPage(ctx context.Context, url string) ([]byte, string, error) {
+	body, finalUrl, contentType, err := downloadPage(ctx, url)
+	if err != nil {
+		return nil, "", err
+	}
+	reader, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return nil, "", err
+	}
+	body, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, finalUrl, nil
+}
+
+// downloadPage downloads the web page at the given URL for fetchPage and fetchHTMLPage.
+func downloadPage(ctx context.Context, url string) ([]byte, string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("User-Agent", httpSettings().UserAgent)
+
+	if err := checkRobots(ctx, req.URL); err != nil {
+		return nil, "", "", err
+	}
+	resp, err := politeDo(pageHTTPClient(), req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", "", httpStatusError(resp)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, httpSettings().MaxBodySize))
+	if err != nil {
+		return nil, "", "", err
+	}
+	return body, resp.Request.URL.String(), resp.Header.Get("Content-Type"), nil
+}
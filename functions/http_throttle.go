@@ -0,0 +1,204 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// maxThrottleWait is the longest a request waits for its host to accept requests again after a Retry-After.
+// Beyond it, the request fails with a retryAfterError instead of holding a worker.
+const maxThrottleWait = time.Minute
+
+// retryAfterError is returned when a host answered 429 Too Many Requests or 503 Service Unavailable with a Retry-After
+// header, or when a request is issued while its host still asks to wait.
+type retryAfterError struct {
+	HTTPError gofeed.HTTPError // The response that asked to wait.
+	Until     time.Time        // The time at which the host accepts requests again.
+}
+
+func (e retryAfterError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", e.HTTPError.Error(), e.Until.Format(time.RFC3339))
+}
+
+// Unwrap returns the HTTP error, so that the status code is recorded like any other HTTP error.
+func (e retryAfterError) Unwrap() error {
+	return e.HTTPError
+}
+
+// throttleSweepInterval is the interval at which the hosts whose deadlines have passed are evicted from the throttle.
+const throttleSweepInterval = 10 * time.Minute
+
+// crawlDelay is the Crawl-delay of a host, kept as long as its robots.txt file is in cache.
+type crawlDelay struct {
+	Delay   time.Duration // The Crawl-delay of the host.
+	Expires time.Time     // The time at which the delay is forgotten.
+}
+
+// hostThrottle spaces out the requests sent to the same host. Two requests to a host are separated by at least
+// FETCH_HOST_DELAY milliseconds, or by the Crawl-delay of its robots.txt file, and no request is sent to a host
+// before the end of the last Retry-After it answered. The hosts are forgotten once their deadlines have passed,
+// so that the maps do not grow with every host ever contacted.
+type hostThrottle struct {
+	mu          sync.Mutex
+	next        map[string]time.Time       // The earliest time of the next request to each host.
+	crawlDelays map[string]crawlDelay      // The Crawl-delay of the hosts that set one.
+	blocked     map[string]retryAfterError // The last Retry-After answered by each host.
+	swept       time.Time                  // The time of the last eviction of the expired hosts.
+}
+
+// throttle is the host throttle shared by the feed fetcher and every page download.
+var throttle = &hostThrottle{
+	next:        make(map[string]time.Time),
+	crawlDelays: make(map[string]crawlDelay),
+	blocked:     make(map[string]retryAfterError),
+}
+
+// setCrawlDelay records the Crawl-delay of a host, for as long as its robots.txt file is cached.
+func (t *hostThrottle) setCrawlDelay(host string, delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if delay <= 0 {
+		delete(t.crawlDelays, host)
+		return
+	}
+	t.crawlDelays[host] = crawlDelay{Delay: delay, Expires: time.Now().Add(robotsCacheTTL)}
+}
+
+// sweep evicts the hosts whose next request time, Retry-After and Crawl-delay have all passed, at most once every
+// throttleSweepInterval. The caller must hold the lock.
+func (t *hostThrottle) sweep(now time.Time) {
+	if now.Sub(t.swept) < throttleSweepInterval {
+		return
+	}
+	t.swept = now
+	for host, next := range t.next {
+		if next.Before(now) {
+			delete(t.next, host)
+		}
+	}
+	for host, retryErr := range t.blocked {
+		if retryErr.Until.Before(now) {
+			delete(t.blocked, host)
+		}
+	}
+	for host, delay := range t.crawlDelays {
+		if delay.Expires.Before(now) {
+			delete(t.crawlDelays, host)
+		}
+	}
+}
+
+// block records that a host asked to wait until the given time before sending it more requests.
+func (t *hostThrottle) block(host string, retryErr retryAfterError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(time.Now())
+	if retryErr.Until.After(t.blocked[host].Until) {
+		t.blocked[host] = retryErr
+	}
+}
+
+// wait blocks until a request can be sent to the given host and reserves its slot.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - host: The lower-cased host name.
+//
+// Returns:
+//   - error: A retryAfterError if the host asked to wait longer than maxThrottleWait, or the error of the context.
+func (t *hostThrottle) wait(ctx context.Context, host string) error {
+	delay := time.Duration(getenvNonNegativeInt("FETCH_HOST_DELAY", 1000)) * time.Millisecond
+
+	t.mu.Lock()
+	now := time.Now()
+	t.sweep(now)
+	if retryErr, ok := t.blocked[host]; ok {
+		if retryErr.Until.Sub(now) > maxThrottleWait {
+			t.mu.Unlock()
+			return retryErr
+		}
+		delete(t.blocked, host)
+		if retryErr.Until.After(t.next[host]) {
+			t.next[host] = retryErr.Until
+		}
+	}
+	at := now
+	if t.next[host].After(at) {
+		at = t.next[host]
+	}
+	t.next[host] = at.Add(max(delay, t.crawlDelays[host].Delay))
+	t.mu.Unlock()
+
+	if at.After(now) {
+		timer := time.NewTimer(at.Sub(now))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// parseRetryAfter parses a Retry-After header, expressed in seconds or as an HTTP date.
+// It returns false if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// httpStatusError returns the error of a response with a non-2xx status code.
+// A 429 or 503 response with a Retry-After header gives a retryAfterError.
+func httpStatusError(resp *http.Response) error {
+	httpErr := gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return httpErr
+	}
+	if until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return retryAfterError{HTTPError: httpErr, Until: until}
+	}
+	return httpErr
+}
+
+// politeDo sends a request once its host accepts requests, and records the Retry-After of the host
+// when it answers 429 Too Many Requests or 503 Service Unavailable.
+//
+// Parameters:
+//   - client: The HTTP client sending the request.
+//   - req: The request to send, with its context.
+//
+// Returns:
+//   - *http.Response: The response of the host.
+//   - error: An error, if any, occurred while waiting for the host or sending the request.
+func politeDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	host := feedHost(req.URL.String())
+	if err := throttle.wait(req.Context(), host); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if retryErr, ok := httpStatusError(resp).(retryAfterError); ok {
+		throttle.block(host, retryErr)
+	}
+	return resp, nil
+}
@@ -0,0 +1,35 @@
+package functions
+
+import (
+	"context"
+	"strings"
+)
+
+// fakeLLMProvider is a deterministic provider for tests and dry runs: it answers with the article following the prompt
+// in the last user message, or with its whole content, as if the model had returned the article untouched, and never
+// calls any service.
+type fakeLLMProvider struct{}
+
+func (fakeLLMProvider) Name() string {
+	return llmProviderFake
+}
+
+func (fakeLLMProvider) Chat(ctx context.Context, model string, messages []LLMMessage, params LLMParams) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	response := &LLMResponse{Model: model}
+	for _, message := range messages {
+		// Roughly four characters per token, like most tokenizers on English text.
+		response.PromptTokens += (len(message.Content) + 3) / 4
+		if message.Role == llmRoleUser {
+			response.Content = message.Content
+			if _, article, found := strings.Cut(message.Content, cleaningInputSeparator); found {
+				response.Content = article
+			}
+		}
+	}
+	response.CompletionTokens = (len(response.Content) + 3) / 4
+	return response, nil
+}
@@ -0,0 +1,138 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The bounds of the delay between two attempts of a chat completion.
+const (
+	llmMinBackoff = 2 * time.Second
+	llmMaxBackoff = 2 * time.Minute
+)
+
+// llmRateLimiter spaces out the chat completions so that they stay below a number of requests per minute and a number
+// of tokens per minute. Both budgets are token buckets refilled continuously, a limit of 0 disabling the budget.
+type llmRateLimiter struct {
+	mu       sync.Mutex
+	rpm      float64   // The maximum number of requests per minute, 0 for no limit.
+	tpm      float64   // The maximum number of tokens per minute, 0 for no limit.
+	requests float64   // The number of requests available.
+	tokens   float64   // The number of tokens available, negative when the requests used more tokens than estimated.
+	refilled time.Time // The last time the budgets were refilled.
+}
+
+// newLLMRateLimiter creates a limiter allowing rpm requests and tpm tokens per minute, starting with full budgets.
+func newLLMRateLimiter(rpm int, tpm int) *llmRateLimiter {
+	return &llmRateLimiter{rpm: float64(rpm), tpm: float64(tpm), requests: float64(rpm), tokens: float64(tpm), refilled: time.Now()}
+}
+
+// refill adds the budget accumulated since the last refill, up to one minute worth of budget.
+func (l *llmRateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.refilled).Minutes()
+	l.refilled = now
+	l.requests = min(l.rpm, l.requests+elapsed*l.rpm)
+	l.tokens = min(l.tpm, l.tokens+elapsed*l.tpm)
+}
+
+// wait blocks until a request of the given estimated number of tokens fits in both budgets, then consumes it.
+// A request larger than the token budget only waits for a full budget, so that it is not blocked forever.
+//
+// Parameters:
+//   - ctx: The context of the request, cancelling it stops the wait.
+//   - tokens: The estimated number of tokens of the request, prompt and completion.
+//
+// Returns:
+//   - error: The error of the context, if it was cancelled during the wait.
+func (l *llmRateLimiter) wait(ctx context.Context, tokens int) error {
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		needed := min(float64(tokens), l.tpm)
+
+		var delay time.Duration
+		if l.rpm > 0 && l.requests < 1 {
+			delay = max(delay, time.Duration((1-l.requests)/l.rpm*float64(time.Minute)))
+		}
+		if l.tpm > 0 && l.tokens < needed {
+			delay = max(delay, time.Duration((needed-l.tokens)/l.tpm*float64(time.Minute)))
+		}
+		if delay == 0 {
+			if l.rpm > 0 {
+				l.requests--
+			}
+			if l.tpm > 0 {
+				l.tokens -= needed
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// record corrects the token budget once the provider has reported the number of tokens a request actually used.
+//
+// Parameters:
+//   - estimated: The number of tokens consumed by wait for the request.
+//   - actual: The number of tokens reported by the provider, 0 if it did not report it.
+func (l *llmRateLimiter) record(estimated int, actual int) {
+	if l.tpm == 0 || actual == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = max(-l.tpm, l.tokens-float64(actual)+min(float64(estimated), l.tpm))
+}
+
+// estimateLLMTokens roughly estimates the number of tokens of a text, about four characters per token.
+func estimateLLMTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// isRetryableLLMError reports whether a failed chat completion is worth trying again: the provider is rate limiting
+// the requests (429) or failing (5xx), or the request or its answer was lost on the network. Any other error, such
+// as an answer that cannot be decoded, would fail again the same way. A cancelled context is never retried.
+func isRetryableLLMError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *llmStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// llmBackoff returns the delay before the next attempt of a chat completion: the delay requested by the Retry-After
+// header of the provider if any, or an exponential backoff with full jitter.
+//
+// Parameters:
+//   - attempt: The number of attempts already made, starting at 1.
+//   - err: The error returned by the last attempt.
+//
+// Returns:
+//   - time.Duration: The delay to wait before the next attempt.
+func llmBackoff(attempt int, err error) time.Duration {
+	var statusErr *llmStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return min(statusErr.RetryAfter, llmMaxBackoff)
+	}
+	backoff := min(llmMinBackoff<<min(attempt-1, 6), llmMaxBackoff)
+	return llmMinBackoff/2 + rand.N(backoff)
+}
@@ -0,0 +1,59 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableLLMError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &llmStatusError{StatusCode: 429}, true},
+		{"server error", &llmStatusError{StatusCode: 500}, true},
+		{"unavailable", &llmStatusError{StatusCode: 503}, true},
+		{"bad request", &llmStatusError{StatusCode: 400}, false},
+		{"unauthorized", &llmStatusError{StatusCode: 401}, false},
+		{"wrapped status", fmt.Errorf("chat: %w", &llmStatusError{StatusCode: 502}), true},
+		{"network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"request error", &url.Error{Op: "Post", URL: "https://api.example.com", Err: io.EOF}, true},
+		{"truncated answer", io.ErrUnexpectedEOF, true},
+		{"wrapped truncated answer", fmt.Errorf("decoding the answer: %w", io.ErrUnexpectedEOF), true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"wrapped cancelled", fmt.Errorf("chat: %w", context.Canceled), false},
+		{"invalid answer", &json.SyntaxError{Offset: 1}, false},
+		{"answer without choice", errors.New("openai answered without any choice"), false},
+		{"other error", errors.New("failed"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableLLMError(tt.err); got != tt.want {
+				t.Errorf("isRetryableLLMError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLLMBackoff(t *testing.T) {
+	if got := llmBackoff(1, &llmStatusError{StatusCode: 429, RetryAfter: 5 * time.Second}); got != 5*time.Second {
+		t.Errorf("llmBackoff() = %v, want the Retry-After delay of 5s", got)
+	}
+	if got := llmBackoff(1, &llmStatusError{StatusCode: 429, RetryAfter: time.Hour}); got != llmMaxBackoff {
+		t.Errorf("llmBackoff() = %v, want the maximum backoff %v", got, llmMaxBackoff)
+	}
+	for attempt := 1; attempt <= 10; attempt++ {
+		if got := llmBackoff(attempt, errors.New("failed")); got < llmMinBackoff/2 || got > llmMinBackoff/2+llmMaxBackoff {
+			t.Errorf("llmBackoff(%d) = %v, out of bounds", attempt, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gage-technologies/mistral-go"
+)
+
+// mistralStatusPattern matches the errors the Mistral client returns for an error status, built by
+// fmt.Errorf("(HTTP Error %d) %s", status, body) in its request method.
+var mistralStatusPattern = regexp.MustCompile(`(?s)^\(HTTP Error (\d{3})\) (.*)`)
+
+// mistralProvider sends the chat completions to the Mistral AI API.
+type mistralProvider struct {
+	client *mistral.MistralClient
+}
+
+// newMistralProvider builds a provider for the Mistral AI API.
+//
+// Parameters:
+//   - apiKey: The Mistral AI API key.
+//   - endpoint: The endpoint of the API, empty for the default one.
+//   - timeout: The maximum duration of a chat completion.
+//
+// Returns:
+//   - *mistralProvider: The provider.
+func newMistralProvider(apiKey string, endpoint string, timeout time.Duration) *mistralProvider {
+	// The Mistral client retries by itself without any backoff, leave the retries to the cleaning workers.
+	return &mistralProvider{client: mistral.NewMistralClient(apiKey, endpoint, 1, timeout)}
+}
+
+// mistralStatusError turns an error of the Mistral client carrying an HTTP status into an llmStatusError, so that the
+// rate limits and the failures of the API are retried. The client declares a MistralAPIError with the status and the
+// headers of the response, but v1.1.0 never returns it: an error status only comes as the text of a fmt error, which is
+// parsed as a fallback. Any other error is returned as is.
+//
+// Parameters:
+//   - err: The error returned by the Mistral client.
+//
+// Returns:
+//   - error: An *llmStatusError if the error carries an HTTP status, or the error itself.
+func mistralStatusError(err error) error {
+	var apiErr *mistral.MistralAPIError
+	if errors.As(err, &apiErr) {
+		statusErr := &llmStatusError{Provider: llmProviderMistral, StatusCode: apiErr.HTTPStatus, Message: apiErr.Message}
+		if values := apiErr.Headers["Retry-After"]; len(values) > 0 {
+			if until, ok := parseRetryAfter(values[0], time.Now()); ok {
+				statusErr.RetryAfter = time.Until(until)
+			}
+		}
+		return statusErr
+	}
+	if match := mistralStatusPattern.FindStringSubmatch(err.Error()); match != nil {
+		statusCode, _ := strconv.Atoi(match[1])
+		return &llmStatusError{Provider: llmProviderMistral, StatusCode: statusCode, Message: match[2]}
+	}
+	return err
+}
+
+func (p *mistralProvider) Name() string {
+	return llmProviderMistral
+}
+
+// Chat sends the messages to a Mistral model. The Mistral client does not take a context, so a cancelled context only
+// prevents the request from being sent.
+func (p *mistralProvider) Chat(ctx context.Context, model string, messages []LLMMessage, params LLMParams) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	chatMessages := make([]mistral.ChatMessage, len(messages))
+	for i, message := range messages {
+		chatMessages[i] = mistral.ChatMessage{Role: message.Role, Content: message.Content}
+	}
+	chatParams := mistral.DefaultChatRequestParams
+	if params.Temperature != nil {
+		chatParams.Temperature = *params.Temperature
+	}
+	if params.MaxTokens > 0 {
+		chatParams.MaxTokens = params.MaxTokens
+	}
+
+	answer, err := p.client.Chat(model, chatMessages, &chatParams)
+	if err != nil {
+		return nil, mistralStatusError(err)
+	}
+	if len(answer.Choices) == 0 {
+		return nil, errors.New("mistral answered without any choice")
+	}
+	return &LLMResponse{
+		Content:          answer.Choices[0].Message.Content,
+		Model:            answer.Model,
+		PromptTokens:     answer.Usage.PromptTokens,
+		CompletionTokens: answer.Usage.CompletionTokens,
+	}, nil
+}
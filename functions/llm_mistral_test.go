@@ -0,0 +1,107 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gage-technologies/mistral-go"
+)
+
+func TestMistralStatusError(t *testing.T) {
+	plainErr := errors.New("connection reset")
+
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+		message    string
+		retryAfter time.Duration
+	}{
+		// The exact error built by the request method of the Mistral client v1.1.0.
+		{"client error", fmt.Errorf("(HTTP Error %d) %s", 429, `{"message":"rate limit exceeded"}`), 429, `{"message":"rate limit exceeded"}`, 0},
+		{"client error with a multi-line body", fmt.Errorf("(HTTP Error %d) %s", 502, "<html>\nBad Gateway\n</html>"), 502, "<html>\nBad Gateway\n</html>", 0},
+		{"api error", mistral.NewMistralAPIError("service unavailable", 503, map[string][]string{"Retry-After": {"30"}}), 503, "service unavailable", 30 * time.Second},
+		{"wrapped api error", fmt.Errorf("chat: %w", mistral.NewMistralAPIError("invalid model", 400, nil)), 400, "invalid model", 0},
+		{"other error", plainErr, 0, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mistralStatusError(tt.err)
+			var statusErr *llmStatusError
+			if !errors.As(err, &statusErr) {
+				if tt.statusCode != 0 || err != tt.err {
+					t.Fatalf("mistralStatusError() = %v, want HTTP %d", err, tt.statusCode)
+				}
+				return
+			}
+			if statusErr.StatusCode != tt.statusCode || statusErr.Message != tt.message || statusErr.Provider != llmProviderMistral {
+				t.Errorf("mistralStatusError() = %+v, want HTTP %d with %q", statusErr, tt.statusCode, tt.message)
+			}
+			if statusErr.RetryAfter > tt.retryAfter || statusErr.RetryAfter < tt.retryAfter-time.Second {
+				t.Errorf("mistralStatusError() retry after %v, want %v", statusErr.RetryAfter, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestMistralProviderStatusErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		retryable  bool
+	}{
+		{"bad request", http.StatusBadRequest, `{"message":"invalid model"}`, false},
+		{"rate limited", http.StatusTooManyRequests, `{"message":"rate limit exceeded"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			provider := newMistralProvider("key", server.URL, 5*time.Second)
+			_, err := provider.Chat(context.Background(), "mistral-tiny", []LLMMessage{{Role: llmRoleUser, Content: "Hello"}}, LLMParams{})
+			var statusErr *llmStatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("Chat() error = %v, want an *llmStatusError", err)
+			}
+			if statusErr.StatusCode != tt.statusCode || statusErr.Message != tt.body || statusErr.Provider != llmProviderMistral {
+				t.Errorf("Chat() error = %+v, want HTTP %d with %q", statusErr, tt.statusCode, tt.body)
+			}
+			if got := isRetryableLLMError(err); got != tt.retryable {
+				t.Errorf("isRetryableLLMError() = %v, want %v", got, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestMistralProviderChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"1","object":"chat.completion","created":0,"model":"mistral-tiny",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"<p>Cleaned</p>"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`))
+	}))
+	defer server.Close()
+
+	provider := newMistralProvider("key", server.URL, 5*time.Second)
+	response, err := provider.Chat(context.Background(), "mistral-tiny", []LLMMessage{{Role: llmRoleUser, Content: "Hello"}}, LLMParams{})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if response.Content != "<p>Cleaned</p>" || response.Model != "mistral-tiny" || response.PromptTokens != 12 || response.CompletionTokens != 3 {
+		t.Errorf("Chat() response = %+v", response)
+	}
+}
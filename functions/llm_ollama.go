@@ -0,0 +1,56 @@
+package functions
+
+import (
+	"context"
+	"net/http"
+)
+
+// ollamaProvider sends the chat completions to a local Ollama server, so that the articles never leave the machine.
+type ollamaProvider struct {
+	baseUrl string       // The base URL of the server, the chat completions are posted to <baseUrl>/api/chat.
+	client  *http.Client // The HTTP client the requests are sent with.
+}
+
+// ollamaOptions holds the sampling options of an Ollama chat request.
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+}
+
+// ollamaRequest is the body of an Ollama chat request.
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+// ollamaAnswer is the body of an Ollama chat response.
+type ollamaAnswer struct {
+	Model           string        `json:"model"`
+	Message         openAIMessage `json:"message"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+func (p *ollamaProvider) Name() string {
+	return llmProviderOllama
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, model string, messages []LLMMessage, params LLMParams) (*LLMResponse, error) {
+	request := ollamaRequest{Model: model, Options: ollamaOptions{Temperature: params.Temperature, NumPredict: params.MaxTokens}}
+	for _, message := range messages {
+		request.Messages = append(request.Messages, openAIMessage{Role: message.Role, Content: message.Content})
+	}
+
+	var answer ollamaAnswer
+	if err := postLLMRequest(ctx, p.client, llmProviderOllama, p.baseUrl+"/api/chat", "", request, &answer); err != nil {
+		return nil, err
+	}
+	return &LLMResponse{
+		Content:          answer.Message.Content,
+		Model:            answer.Model,
+		PromptTokens:     answer.PromptEvalCount,
+		CompletionTokens: answer.EvalCount,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// openAIProvider sends the chat completions to an endpoint compatible with the OpenAI chat completions API,
+// such as OpenAI itself, a llama.cpp server or any other OpenAI-compatible gateway.
+type openAIProvider struct {
+	name    string       // The name of the provider, openai or llamacpp.
+	baseUrl string       // The base URL of the API, the chat completions are posted to <baseUrl>/chat/completions.
+	apiKey  string       // The API key sent as a bearer token, if any.
+	client  *http.Client // The HTTP client the requests are sent with.
+}
+
+// openAIMessage is a message of an OpenAI chat completion.
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// openAIRequest is the body of an OpenAI chat completion request.
+type openAIRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+}
+
+// openAIAnswer is the body of an OpenAI chat completion response.
+type openAIAnswer struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, model string, messages []LLMMessage, params LLMParams) (*LLMResponse, error) {
+	request := openAIRequest{Model: model, Temperature: params.Temperature, MaxTokens: params.MaxTokens}
+	for _, message := range messages {
+		request.Messages = append(request.Messages, openAIMessage{Role: message.Role, Content: message.Content})
+	}
+
+	var answer openAIAnswer
+	if err := postLLMRequest(ctx, p.client, p.name, p.baseUrl+"/chat/completions", p.apiKey, request, &answer); err != nil {
+		return nil, err
+	}
+	if len(answer.Choices) == 0 {
+		return nil, errors.New(p.name + " answered without any choice")
+	}
+	return &LLMResponse{
+		Content:          answer.Choices[0].Message.Content,
+		Model:            answer.Model,
+		PromptTokens:     answer.Usage.PromptTokens,
+		CompletionTokens: answer.Usage.CompletionTokens,
+	}, nil
+}
@@ -0,0 +1,201 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gowebly "github.com/gowebly/helpers"
+)
+
+// llmRoleUser is the role of the messages sent to a chat completion on behalf of the user.
+const llmRoleUser = "user"
+
+// The LLM providers that can be set by LLM_PROVIDER.
+const (
+	llmProviderMistral  = "mistral"  // The Mistral AI API, the default.
+	llmProviderOpenAI   = "openai"   // Any endpoint compatible with the OpenAI chat completions API.
+	llmProviderOllama   = "ollama"   // A local Ollama server.
+	llmProviderLlamaCpp = "llamacpp" // A local llama.cpp server, through its OpenAI-compatible API.
+	llmProviderFake     = "fake"     // A deterministic provider echoing the content, for tests and dry runs.
+)
+
+// LLMMessage is a message of a chat completion.
+type LLMMessage struct {
+	Role    string // The role of the author of the message: system, user or assistant.
+	Content string // The content of the message.
+}
+
+// LLMParams holds the optional sampling parameters of a chat completion. The zero value uses the defaults of the provider.
+type LLMParams struct {
+	Temperature *float64 // The sampling temperature, nil for the default of the provider.
+	MaxTokens   int      // The maximum number of tokens to generate, 0 for the default of the provider.
+}
+
+// LLMResponse is the answer of a chat completion.
+type LLMResponse struct {
+	Content          string // The content of the message generated by the model.
+	Model            string // The model that generated the message, as reported by the provider.
+	PromptTokens     int    // The number of tokens of the prompt, 0 if the provider does not report it.
+	CompletionTokens int    // The number of tokens generated, 0 if the provider does not report it.
+}
+
+// LLMProvider is implemented by the LLM services the cleaning pipeline can send articles to.
+type LLMProvider interface {
+	// Name returns the name of the provider, as set by LLM_PROVIDER.
+	Name() string
+	// Chat sends the messages to the model and returns the message it generated.
+	Chat(ctx context.Context, model string, messages []LLMMessage, params LLMParams) (*LLMResponse, error)
+}
+
+// llmStatusError is returned when an LLM provider answers with an error status code.
+type llmStatusError struct {
+	Provider   string        // The name of the provider.
+	StatusCode int           // The HTTP status code of the response.
+	RetryAfter time.Duration // The delay requested by the Retry-After header, 0 if there is none.
+	Message    string        // The beginning of the body of the response.
+}
+
+func (e *llmStatusError) Error() string {
+	return fmt.Sprintf("%v answered HTTP %d: %v", e.Provider, e.StatusCode, e.Message)
+}
+
+// llmConfig holds the settings of the LLM provider used by the cleaning pipeline.
+type llmConfig struct {
+	Provider string        // The name of the provider.
+	Model    string        // The model used for the chat completions.
+	BaseUrl  string        // The base URL of the API of the provider, empty for its default.
+	ApiKey   string        // The API key sent to the provider, if any.
+	Timeout  time.Duration // The maximum duration of a chat completion.
+	Params   LLMParams     // The sampling parameters of the chat completions.
+}
+
+// loadLLMConfig reads the LLM settings from the LLM_PROVIDER, LLM_MODEL, LLM_BASE_URL, LLM_API_KEY, LLM_TIMEOUT,
+// LLM_TEMPERATURE and LLM_MAX_TOKENS environment variables. The Mistral provider falls back to MISTRAL_API_KEY
+// and MISTRAL_MODEL_TINY.
+func loadLLMConfig() llmConfig {
+	config := llmConfig{
+		Provider: strings.ToLower(strings.TrimSpace(gowebly.Getenv("LLM_PROVIDER", llmProviderMistral))),
+		Model:    strings.TrimSpace(gowebly.Getenv("LLM_MODEL", "")),
+		BaseUrl:  strings.TrimRight(strings.TrimSpace(gowebly.Getenv("LLM_BASE_URL", "")), "/"),
+		ApiKey:   strings.TrimSpace(gowebly.Getenv("LLM_API_KEY", "")),
+		Timeout:  time.Duration(getenvInt("LLM_TIMEOUT", 120)) * time.Second,
+		Params:   LLMParams{MaxTokens: getenvNonNegativeInt("LLM_MAX_TOKENS", 0)},
+	}
+	if config.Provider == llmProviderMistral {
+		if config.ApiKey == "" {
+			config.ApiKey = gowebly.Getenv("MISTRAL_API_KEY", "")
+		}
+		if config.Model == "" {
+			config.Model = gowebly.Getenv("MISTRAL_MODEL_TINY", "")
+		}
+	}
+
+	if strValue := strings.TrimSpace(gowebly.Getenv("LLM_TEMPERATURE", "")); strValue != "" {
+		if temperature, err := strconv.ParseFloat(strValue, 64); err == nil && temperature >= 0 {
+			config.Params.Temperature = &temperature
+		} else {
+			slog.Warn("Invalid environment variable, using the default value", "Variable", "LLM_TEMPERATURE", "Value", strValue)
+		}
+	}
+	return config
+}
+
+// newLLMProvider builds the LLM provider selected by the configuration.
+//
+// Parameters:
+//   - config: The LLM settings.
+//
+// Returns:
+//   - LLMProvider: The provider.
+//   - error: An error, if the provider is unknown or a required setting is missing.
+func newLLMProvider(config llmConfig) (LLMProvider, error) {
+	if config.Model == "" && config.Provider != llmProviderFake {
+		return nil, errors.New("LLM model is not set as an environment variable")
+	}
+
+	client := &http.Client{Timeout: config.Timeout}
+	switch config.Provider {
+	case llmProviderMistral:
+		if config.ApiKey == "" {
+			return nil, errors.New("mistral API key is not set as an environment variable")
+		}
+		return newMistralProvider(config.ApiKey, config.BaseUrl, config.Timeout), nil
+	case llmProviderOpenAI:
+		baseUrl := config.BaseUrl
+		if baseUrl == "" {
+			baseUrl = "https://api.openai.com/v1"
+		}
+		return &openAIProvider{name: config.Provider, baseUrl: baseUrl, apiKey: config.ApiKey, client: client}, nil
+	case llmProviderLlamaCpp:
+		baseUrl := config.BaseUrl
+		if baseUrl == "" {
+			baseUrl = "http://localhost:8080/v1"
+		}
+		return &openAIProvider{name: config.Provider, baseUrl: baseUrl, apiKey: config.ApiKey, client: client}, nil
+	case llmProviderOllama:
+		baseUrl := config.BaseUrl
+		if baseUrl == "" {
+			baseUrl = "http://localhost:11434"
+		}
+		return &ollamaProvider{baseUrl: baseUrl, client: client}, nil
+	case llmProviderFake:
+		return fakeLLMProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", config.Provider)
+	}
+}
+
+// postLLMRequest sends a JSON request to an LLM API and decodes its JSON answer.
+//
+// Parameters:
+//   - ctx: The context of the request, cancelling it aborts the request.
+//   - client: The HTTP client to send the request with.
+//   - provider: The name of the provider, used in the errors.
+//   - url: The URL of the endpoint.
+//   - apiKey: The API key sent as a bearer token, if any.
+//   - payload: The body of the request, encoded as JSON.
+//   - answer: The value the body of the response is decoded into.
+//
+// Returns:
+//   - error: An error, if any, occurred while sending the request, a *llmStatusError if the provider answered with an error status.
+func postLLMRequest(ctx context.Context, client *http.Client, provider string, url string, apiKey string, payload any, answer any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		statusErr := &llmStatusError{Provider: provider, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+		if until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			statusErr.RetryAfter = time.Until(until)
+		}
+		return statusErr
+	}
+	return json.NewDecoder(resp.Body).Decode(answer)
+}
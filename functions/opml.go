@@ -0,0 +1,232 @@
+package functions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cl3mcg/speakrine/databases"
+)
+
+// opmlDocument represents an OPML 2.0 document.
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated,omitempty"`
+	Outline []opmlOutline `xml:"body>outline"`
+}
+
+// opmlOutline represents an outline element of an OPML document.
+// An outline with an xmlUrl attribute is a subscription, any other outline is a folder.
+type opmlOutline struct {
+	Text    string        `xml:"text,attr"`
+	Title   string        `xml:"title,attr,omitempty"`
+	Type    string        `xml:"type,attr,omitempty"`
+	XMLUrl  string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLUrl string        `xml:"htmlUrl,attr,omitempty"`
+	Outline []opmlOutline `xml:"outline"`
+}
+
+// opmlFeedType maps the type attribute of an OPML outline to a value allowed in the rss_feeds.type column.
+// Most readers use "rss" for every kind of feed, so unknown types default to "rss".
+func opmlFeedType(outlineType string) string {
+	switch strings.ToLower(strings.TrimSpace(outlineType)) {
+	case "atom":
+		return "atom"
+	case "json":
+		return "json"
+	default:
+		return "rss"
+	}
+}
+
+// ImportOPML creates the rss_feeds rows of the subscriptions found in an OPML document for the given user.
+// The names of the folders containing a subscription are stored in its categories, from the outermost to the innermost.
+// Subscriptions the user already has are left untouched. The feeds are inserted in a single transaction, so that a
+// document failing partway through imports nothing.
+//
+// Parameters:
+//   - userId: The ID of the user to subscribe.
+//   - r: The reader providing the OPML document.
+//
+// Returns:
+//   - int: The number of feeds created, 0 if the import failed.
+//   - error: An error, if any, occurred while parsing the document or inserting the feeds.
+func ImportOPML(userId int, r io.Reader) (int, error) {
+	db := databases.GetDB()
+
+	var doc opmlDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		slog.Error("Error parsing OPML document", "Error", err)
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting the OPML import transaction", "Error", err)
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	query := `
+		INSERT INTO rss_feeds (user_id, common_name, type, url, categories)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = id
+	`
+
+	imported := 0
+	var walk func(outlines []opmlOutline, folders []string) error
+	walk = func(outlines []opmlOutline, folders []string) error {
+		for _, outline := range outlines {
+			name := strings.TrimSpace(outline.Text)
+			if name == "" {
+				name = strings.TrimSpace(outline.Title)
+			}
+
+			url := strings.TrimSpace(outline.XMLUrl)
+			if url == "" {
+				// A folder, its name becomes a category of the feeds it contains.
+				subFolders := folders
+				if name != "" {
+					subFolders = append(append([]string{}, folders...), name)
+				}
+				if err := walk(outline.Outline, subFolders); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if name == "" {
+				name = url
+			}
+			var categories sql.NullString
+			if len(folders) > 0 {
+				jsonCategories, err := json.Marshal(folders)
+				if err != nil {
+					return err
+				}
+				categories = sql.NullString{String: string(jsonCategories), Valid: true}
+			}
+
+			res, err := tx.Exec(query, userId, name, opmlFeedType(outline.Type), url, categories)
+			if err != nil {
+				slog.Error("Error inserting rss_feeds row from OPML", "Feed URL", url, "Error", err)
+				return err
+			}
+			// One row affected means the feed was inserted, zero means the user already had it.
+			if count, err := res.RowsAffected(); err == nil && count == 1 {
+				imported++
+			}
+		}
+		return nil
+	}
+
+	if err := walk(doc.Outline, nil); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing the OPML import", "Error", err)
+		return 0, err
+	}
+	return imported, nil
+}
+
+// ExportOPML writes the feeds of the given user as an OPML 2.0 document.
+// The categories of a feed are turned back into nested folders, from the outermost to the innermost.
+//
+// Parameters:
+//   - userId: The ID of the user whose feeds are exported.
+//   - w: The writer receiving the OPML document.
+//
+// Returns:
+//   - error: An error, if any, occurred while querying the feeds or writing the document.
+func ExportOPML(userId int, w io.Writer) error {
+	db := databases.GetDB()
+
+	query := `
+		SELECT common_name, type, url, categories
+		FROM rss_feeds
+		WHERE user_id = ?
+		ORDER BY common_name
+	`
+
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		slog.Error("Error querying rss_feeds", "Error", err)
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	doc := opmlDocument{Version: "2.0", Title: "Speakrine subscriptions", Created: time.Now().Format(time.RFC1123Z)}
+	for rows.Next() {
+		var name, feedType, url string
+		var jsonCategories sql.NullString
+		if err := rows.Scan(&name, &feedType, &url, &jsonCategories); err != nil {
+			slog.Error("Error scanning rss_feeds row", "Error", err)
+			return err
+		}
+
+		var folders []string
+		if jsonCategories.Valid {
+			if err := json.Unmarshal([]byte(jsonCategories.String), &folders); err != nil {
+				slog.Warn("Ignoring invalid categories of feed", "Feed URL", url, "Error", err)
+				folders = nil
+			}
+		}
+
+		// Scraped feeds have no feed URL another reader could use.
+		if strings.ToLower(feedType) == "scrap" {
+			continue
+		}
+		outlineType := strings.ToLower(feedType)
+		if outlineType == "other" {
+			outlineType = "rss"
+		}
+		feedOutline := opmlOutline{Text: name, Title: name, Type: outlineType, XMLUrl: url}
+
+		// Find or create the nested folders of the feed.
+		outlines := &doc.Outline
+		for _, folder := range folders {
+			index := -1
+			for i, outline := range *outlines {
+				if outline.XMLUrl == "" && outline.Text == folder {
+					index = i
+					break
+				}
+			}
+			if index == -1 {
+				*outlines = append(*outlines, opmlOutline{Text: folder, Title: folder})
+				index = len(*outlines) - 1
+			}
+			outlines = &(*outlines)[index].Outline
+		}
+		*outlines = append(*outlines, feedOutline)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over rss_feeds rows", "Error", err)
+		return err
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,128 @@
+package functions
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cl3mcg/speakrine/assets"
+	gowebly "github.com/gowebly/helpers"
+)
+
+// cleaningPromptName is the name of the prompt instructing the model how to clean an article.
+const cleaningPromptName = "clean_article_content"
+
+// promptFilePattern matches the file names of the prompts, speakrine_prompt_<name>_v<version>.txt.
+var promptFilePattern = regexp.MustCompile(`^speakrine_prompt_([a-z0-9_]+)_v(\d+)\.txt$`)
+
+// prompt is a version of a prompt sent to the LLM.
+type prompt struct {
+	Name    string // The name of the prompt, such as clean_article_content.
+	Version int    // The version of the prompt, increased every time its text changes.
+	Text    string // The text of the prompt.
+}
+
+// VersionLabel returns the version of the prompt as recorded with the cleaned items, such as v1.
+func (p prompt) VersionLabel() string {
+	return fmt.Sprintf("v%d", p.Version)
+}
+
+// parsePromptVersion parses a version label such as v2, or 2.
+func parsePromptVersion(label string) (int, bool) {
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(label)), "v"))
+	return version, err == nil && version > 0
+}
+
+// readPrompts reads the prompts of a directory into the registry, replacing the versions it already holds.
+func readPrompts(fsys fs.FS, dir string, registry map[string]map[int]prompt) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		match := promptFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		text, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(dir, entry.Name())))
+		if err != nil {
+			return err
+		}
+		version, _ := strconv.Atoi(match[2])
+		if registry[match[1]] == nil {
+			registry[match[1]] = make(map[int]prompt)
+		}
+		registry[match[1]][version] = prompt{Name: match[1], Version: version, Text: string(text)}
+	}
+	return nil
+}
+
+// loadPrompts loads the prompts embedded in the binary, then the prompts of the PROMPT_DIR directory, if set,
+// which override the embedded versions of the same name and add new ones without rebuilding the binary.
+//
+// Returns:
+//   - map[string]map[int]prompt: The versions of the prompts by name.
+//   - error: An error, if any, occurred while reading the prompts.
+func loadPrompts() (map[string]map[int]prompt, error) {
+	registry := make(map[string]map[int]prompt)
+	if err := readPrompts(assets.Prompts, "prompt", registry); err != nil {
+		return nil, err
+	}
+	if dir := strings.TrimSpace(gowebly.Getenv("PROMPT_DIR", "")); dir != "" {
+		if err := readPrompts(os.DirFS(dir), ".", registry); err != nil {
+			slog.Error("Error reading the prompts of PROMPT_DIR", "Directory", dir, "Error", err)
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// getPrompt returns a version of a prompt.
+//
+// Parameters:
+//   - name: The name of the prompt.
+//   - version: The version of the prompt, such as v2, or an empty string for its latest version.
+//
+// Returns:
+//   - prompt: The prompt.
+//   - error: An error, if the prompts cannot be read or the version does not exist.
+func getPrompt(name string, version string) (prompt, error) {
+	registry, err := loadPrompts()
+	if err != nil {
+		return prompt{}, err
+	}
+	versions := registry[name]
+	if len(versions) == 0 {
+		return prompt{}, fmt.Errorf("unknown prompt %q", name)
+	}
+
+	if strings.TrimSpace(version) == "" {
+		latest := prompt{}
+		for _, p := range versions {
+			if p.Version > latest.Version {
+				latest = p
+			}
+		}
+		return latest, nil
+	}
+	number, ok := parsePromptVersion(version)
+	if !ok {
+		return prompt{}, fmt.Errorf("invalid prompt version %q", version)
+	}
+	p, ok := versions[number]
+	if !ok {
+		return prompt{}, fmt.Errorf("unknown version %q of prompt %q", version, name)
+	}
+	return p, nil
+}
+
+// cleaningPrompt returns the prompt the items are cleaned with: the version set by LLM_PROMPT_VERSION,
+// or the latest version of the cleaning prompt.
+func cleaningPrompt() (prompt, error) {
+	return getPrompt(cleaningPromptName, gowebly.Getenv("LLM_PROMPT_VERSION", ""))
+}
@@ -0,0 +1,198 @@
+package functions
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// minArticleLength is the minimum length of the text of an extracted article for the extraction to be considered successful.
+const minArticleLength = 250
+
+var (
+	// positiveCandidatePattern matches class and id attributes that usually denote the main content of a page.
+	positiveCandidatePattern = regexp.MustCompile(`(?i)article|body|content|entry|hentry|main|page|post|text|blog|story`)
+	// negativeCandidatePattern matches class and id attributes that usually denote page furniture.
+	negativeCandidatePattern = regexp.MustCompile(`(?i)comment|com-|contact|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|social|tags|tool|widget|banner|combx|disqus|extra|header|legends|menu|modal|nav|newsletter|popup|remark|rss|subscribe`)
+)
+
+// readabilityNoiseElements are removed from the page before scoring, along with their content.
+var readabilityNoiseElements = []string{"script", "style", "noscript", "nav", "aside", "footer", "header", "form", "iframe", "button", "select", "input", "textarea", "svg", "template"}
+
+// nodeText returns the concatenated text of an HTML node and its descendants.
+func nodeText(n *html.Node) string {
+	var buf strings.Builder
+	var traverse func(n *html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(n)
+	return buf.String()
+}
+
+// linkDensity returns the share of the text of an HTML node that is inside links.
+func linkDensity(n *html.Node) float64 {
+	textLength := len(strings.TrimSpace(nodeText(n)))
+	if textLength == 0 {
+		return 0
+	}
+
+	linkLength := 0
+	var traverse func(n *html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			linkLength += len(strings.TrimSpace(nodeText(n)))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(n)
+	return float64(linkLength) / float64(textLength)
+}
+
+// classWeight scores an HTML element from its class and id attributes.
+func classWeight(n *html.Node) float64 {
+	weight := 0.0
+	for _, attr := range n.Attr {
+		if attr.Key != "class" && attr.Key != "id" {
+			continue
+		}
+		if negativeCandidatePattern.MatchString(attr.Val) {
+			weight -= 25
+		}
+		if positiveCandidatePattern.MatchString(attr.Val) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// initialScore scores an HTML element from its tag name and its class and id attributes.
+func initialScore(n *html.Node) float64 {
+	score := classWeight(n)
+	switch n.Data {
+	case "article":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+// extractArticleContent extracts the main article of an HTML page with a readability-style scoring algorithm.
+// Every paragraph scores its parent and, for half of it, its grandparent from its length and its number of commas.
+// The candidate with the best score, weighted by its link density, is kept along with the siblings that score
+// well enough or look like paragraphs of the article.
+//
+// Parameters:
+//   - page: The HTML content of the page.
+//
+// Returns:
+//   - string: The HTML of the main article.
+//   - error: An error, if the page cannot be parsed or no article long enough is found.
+func extractArticleContent(page []byte) (string, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+	removeUndesirableElements(doc, readabilityNoiseElements)
+	removeComments(doc)
+
+	// Score the parents of every paragraph.
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+
+	var traverse func(n *html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "p" || n.Data == "pre" || n.Data == "td") {
+			text := strings.TrimSpace(nodeText(n))
+			if len(text) >= 25 {
+				score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+				addScore(n.Parent, score)
+				if n.Parent != nil {
+					addScore(n.Parent.Parent, score/2)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(doc)
+
+	// Pick the best candidate, penalized by its link density.
+	var top *html.Node
+	topScore := 0.0
+	for _, candidate := range candidates {
+		scores[candidate] *= 1 - linkDensity(candidate)
+		if top == nil || scores[candidate] > topScore {
+			top = candidate
+			topScore = scores[candidate]
+		}
+	}
+	if top == nil {
+		return "", errors.New("no article content found")
+	}
+
+	// Keep the siblings of the best candidate that belong to the article.
+	threshold := max(10, topScore*0.2)
+	var buf bytes.Buffer
+	textLength := 0
+	siblings := []*html.Node{top}
+	if top.Parent != nil {
+		siblings = nil
+		for c := top.Parent.FirstChild; c != nil; c = c.NextSibling {
+			siblings = append(siblings, c)
+		}
+	}
+	for _, sibling := range siblings {
+		keep := sibling == top
+		if !keep && sibling.Type == html.ElementNode {
+			if score, ok := scores[sibling]; ok && score >= threshold {
+				keep = true
+			} else if sibling.Data == "p" {
+				text := strings.TrimSpace(nodeText(sibling))
+				density := linkDensity(sibling)
+				keep = (len(text) > 80 && density < 0.25) || (len(text) > 0 && density == 0 && strings.Contains(text, ". "))
+			}
+		}
+		if !keep {
+			continue
+		}
+		textLength += len(strings.TrimSpace(nodeText(sibling)))
+		if err := html.Render(&buf, sibling); err != nil {
+			return "", err
+		}
+	}
+
+	if textLength < minArticleLength {
+		return "", errors.New("the extracted article is too short")
+	}
+	return "<div>" + buf.String() + "</div>", nil
+}
@@ -0,0 +1,307 @@
+package functions
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// robotsCacheTTL is the time a robots.txt file is kept in cache.
+	robotsCacheTTL = 24 * time.Hour
+	// robotsErrorTTL is the time a host whose robots.txt could not be downloaded is considered fully disallowed.
+	robotsErrorTTL = time.Hour
+	// maxRobotsSize is the maximum number of bytes read from a robots.txt file.
+	maxRobotsSize = 500 << 10
+)
+
+// robotsRule is an Allow or Disallow rule of a robots.txt group.
+type robotsRule struct {
+	Allow bool   // Whether the rule allows the paths it matches.
+	Path  string // The path pattern, which may contain the * and $ wildcards.
+}
+
+// robotsGroup holds the rules of a robots.txt group, shared by one or more user agents.
+type robotsGroup struct {
+	Agents     []string      // The lower-cased user agents of the group.
+	Rules      []robotsRule  // The Allow and Disallow rules of the group.
+	CrawlDelay time.Duration // The Crawl-delay of the group, 0 if not set.
+}
+
+// robotsPolicy is the part of a robots.txt file that applies to the fetcher.
+type robotsPolicy struct {
+	DisallowAll bool          // Whether every path is disallowed, used while the robots.txt file cannot be downloaded.
+	Rules       []robotsRule  // The rules that apply to the fetcher.
+	CrawlDelay  time.Duration // The Crawl-delay that applies to the fetcher.
+}
+
+// parseRobots parses a robots.txt file and keeps the group that applies to the given user agent token,
+// that is the group with the longest user agent contained in the token, or the * group.
+//
+// Parameters:
+//   - body: The content of the robots.txt file.
+//   - agentToken: The lower-cased product token of the User-Agent of the fetcher.
+//
+// Returns:
+//   - robotsPolicy: The rules and Crawl-delay that apply to the fetcher.
+func parseRobots(body []byte, agentToken string) robotsPolicy {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share the same group.
+			if !inAgents {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+			}
+			current.Agents = append(current.Agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil || (key == "disallow" && value == "") {
+				continue
+			}
+			current.Rules = append(current.Rules, robotsRule{Allow: key == "allow", Path: value})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.CrawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	// Merge the groups of the most specific user agent that matches.
+	var policy robotsPolicy
+	bestMatch := -1
+	for _, group := range groups {
+		for _, agent := range group.Agents {
+			match := -1
+			if agent == "*" {
+				match = 0
+			} else if agent != "" && strings.Contains(agentToken, agent) {
+				match = len(agent)
+			}
+			if match < 0 || match < bestMatch {
+				continue
+			}
+			if match > bestMatch {
+				policy = robotsPolicy{}
+				bestMatch = match
+			}
+			policy.Rules = append(policy.Rules, group.Rules...)
+			policy.CrawlDelay = max(policy.CrawlDelay, group.CrawlDelay)
+			break
+		}
+	}
+	return policy
+}
+
+// robotsPatternMatch reports whether a robots.txt path pattern matches the given path.
+// The * wildcard matches any sequence of characters and a trailing $ anchors the pattern at the end of the path.
+func robotsPatternMatch(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		// The last part must end the path when the pattern is anchored.
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		index := strings.Index(rest, part)
+		if index < 0 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// allows reports whether the policy allows the given path. The longest matching rule wins, and Allow wins a tie.
+func (p robotsPolicy) allows(path string) bool {
+	if p.DisallowAll {
+		return false
+	}
+
+	allowed := true
+	longest := -1
+	for _, rule := range p.Rules {
+		if !robotsPatternMatch(rule.Path, path) {
+			continue
+		}
+		if len(rule.Path) > longest || (len(rule.Path) == longest && rule.Allow) {
+			allowed = rule.Allow
+			longest = len(rule.Path)
+		}
+	}
+	return allowed
+}
+
+// robotsEntry is a robots.txt policy in cache, along with its expiration time.
+type robotsEntry struct {
+	ready   chan struct{} // Closed once the policy has been downloaded.
+	policy  robotsPolicy
+	expires time.Time
+}
+
+// robotsCache keeps the robots.txt policy of each host, so that it is downloaded once per host and per day.
+type robotsCache struct {
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+// robots is the robots.txt cache shared by every page download.
+var robots = &robotsCache{entries: make(map[string]*robotsEntry)}
+
+// errDisallowedByRobots is returned when the robots.txt file of a host disallows a page.
+var errDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// robotsAgentToken returns the lower-cased product token of the configured User-Agent, such as "speakrine".
+func robotsAgentToken() string {
+	token, _, _ := strings.Cut(httpSettings().UserAgent, "/")
+	return strings.ToLower(strings.TrimSpace(token))
+}
+
+// policy returns the robots.txt policy of the host of the given URL, downloading it when it is not in cache.
+// Concurrent callers for the same host wait for a single download.
+func (c *robotsCache) policy(ctx context.Context, pageUrl *url.URL) (robotsPolicy, error) {
+	key := strings.ToLower(pageUrl.Scheme + "://" + pageUrl.Host)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		select {
+		case <-entry.ready:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+		c.mu.Unlock()
+
+		entry.policy, entry.expires = downloadRobots(ctx, pageUrl)
+		close(entry.ready)
+		if ctx.Err() != nil {
+			return robotsPolicy{}, ctx.Err()
+		}
+		return entry.policy, nil
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.policy, nil
+	case <-ctx.Done():
+		return robotsPolicy{}, ctx.Err()
+	}
+}
+
+// downloadRobots downloads and parses the robots.txt file of the host of the given URL.
+// A missing robots.txt file (4xx) allows every page. When the server fails or cannot be reached,
+// every page of the host is disallowed for robotsErrorTTL.
+//
+// Parameters:
+//   - ctx: The context of the download.
+//   - pageUrl: The URL of a page of the host.
+//
+// Returns:
+//   - robotsPolicy: The policy that applies to the fetcher.
+//   - time.Time: The time at which the policy expires.
+func downloadRobots(ctx context.Context, pageUrl *url.URL) (robotsPolicy, time.Time) {
+	now := time.Now()
+	robotsUrl := &url.URL{Scheme: pageUrl.Scheme, Host: pageUrl.Host, Path: "/robots.txt"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsUrl.String(), nil)
+	if err != nil {
+		return robotsPolicy{DisallowAll: true}, now.Add(robotsErrorTTL)
+	}
+	req.Header.Set("User-Agent", httpSettings().UserAgent)
+
+	resp, err := politeDo(robotsHTTPClient(), req)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Do not keep the outcome of an aborted download.
+			return robotsPolicy{DisallowAll: true}, now
+		}
+		return robotsPolicy{DisallowAll: true}, now.Add(robotsErrorTTL)
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+		if err != nil {
+			return robotsPolicy{DisallowAll: true}, now.Add(robotsErrorTTL)
+		}
+		return parseRobots(body, robotsAgentToken()), now.Add(robotsCacheTTL)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return robotsPolicy{}, now.Add(robotsCacheTTL)
+	default:
+		return robotsPolicy{DisallowAll: true}, now.Add(robotsErrorTTL)
+	}
+}
+
+// checkRobots returns errDisallowedByRobots if the robots.txt file of its host disallows the given URL.
+// The Crawl-delay of the host is recorded by the host throttle.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - pageUrl: The URL about to be requested.
+//
+// Returns:
+//   - error: errDisallowedByRobots if the URL is disallowed, or the error of the context.
+func checkRobots(ctx context.Context, pageUrl *url.URL) error {
+	policy, err := robots.policy(ctx, pageUrl)
+	if err != nil {
+		return err
+	}
+	throttle.setCrawlDelay(feedHost(pageUrl.String()), policy.CrawlDelay)
+
+	path := pageUrl.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if pageUrl.RawQuery != "" {
+		path += "?" + pageUrl.RawQuery
+	}
+	if !policy.allows(path) {
+		return fmt.Errorf("%v: %w", pageUrl, errDisallowedByRobots)
+	}
+	return nil
+}
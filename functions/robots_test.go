@@ -0,0 +1,216 @@
+package functions
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robotsTxt := `# Comments and unknown lines are ignored
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: *
+Disallow: /private
+Crawl-delay: 2
+
+User-agent: speakrine
+User-agent: otherbot
+Disallow: /drafts # trailing comment
+Allow: /drafts/public
+Disallow:
+Crawl-delay: 0.5
+
+User-agent: speak
+Disallow: /everything
+`
+
+	tests := []struct {
+		name       string
+		agentToken string
+		rules      []robotsRule
+		crawlDelay time.Duration
+	}{
+		{
+			name:       "longest matching agent",
+			agentToken: "speakrine",
+			rules:      []robotsRule{{Allow: false, Path: "/drafts"}, {Allow: true, Path: "/drafts/public"}},
+			crawlDelay: 500 * time.Millisecond,
+		},
+		{
+			name:       "agent of a shared group",
+			agentToken: "otherbot",
+			rules:      []robotsRule{{Allow: false, Path: "/drafts"}, {Allow: true, Path: "/drafts/public"}},
+			crawlDelay: 500 * time.Millisecond,
+		},
+		{
+			name:       "wildcard group",
+			agentToken: "unknownbot",
+			rules:      []robotsRule{{Allow: false, Path: "/private"}},
+			crawlDelay: 2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := parseRobots([]byte(robotsTxt), tt.agentToken)
+			if len(policy.Rules) != len(tt.rules) {
+				t.Fatalf("parseRobots() rules = %+v, want %+v", policy.Rules, tt.rules)
+			}
+			for i, rule := range tt.rules {
+				if policy.Rules[i] != rule {
+					t.Errorf("parseRobots() rule %d = %+v, want %+v", i, policy.Rules[i], rule)
+				}
+			}
+			if policy.CrawlDelay != tt.crawlDelay {
+				t.Errorf("parseRobots() crawl delay = %v, want %v", policy.CrawlDelay, tt.crawlDelay)
+			}
+		})
+	}
+
+	if policy := parseRobots([]byte("Disallow: /\nUser-agent: other\nDisallow: /"), "speakrine"); len(policy.Rules) != 0 {
+		t.Errorf("parseRobots() rules = %+v, want none for rules outside of any matching group", policy.Rules)
+	}
+}
+
+func TestRobotsPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private/page.html", true},
+		{"/private", "/public", false},
+		{"/private/", "/private", false},
+		{"/*.pdf", "/docs/file.pdf", true},
+		{"/*.pdf", "/docs/file.pdf?download=1", true},
+		{"/*.pdf$", "/docs/file.pdf", true},
+		{"/*.pdf$", "/docs/file.pdf?download=1", false},
+		{"/page$", "/page", true},
+		{"/page$", "/page/2", false},
+		{"/a*b*c", "/a-x-b-y-c-z", true},
+		{"/a*b*c", "/a-x-c-y-b", false},
+		{"/a*c$", "/abcbc", true},
+		{"/a*c$", "/abcb", false},
+		{"*", "/anything", true},
+		{"/*", "/", true},
+	}
+	for _, tt := range tests {
+		if got := robotsPatternMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsPatternMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsPolicyAllows(t *testing.T) {
+	policy := robotsPolicy{Rules: []robotsRule{
+		{Allow: false, Path: "/drafts"},
+		{Allow: true, Path: "/drafts/public"},
+		{Allow: false, Path: "/*.pdf$"},
+		{Allow: true, Path: "/tie"},
+		{Allow: false, Path: "/tie"},
+		{Allow: false, Path: "/shop/*"},
+		{Allow: true, Path: "/shop/cart"},
+	}}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/drafts/secret", false},
+		{"/drafts/public/post", true},
+		{"/files/report.pdf", false},
+		{"/files/report.pdf?page=2", true},
+		{"/tie/page", true},
+		{"/shop/item", false},
+		{"/shop/cart", true},
+	}
+	for _, tt := range tests {
+		if got := policy.allows(tt.path); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if (robotsPolicy{DisallowAll: true}).allows("/") {
+		t.Error("allows() = true for a policy disallowing everything")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"120", now.Add(2 * time.Minute), true},
+		{" 0 ", now, true},
+		{"Sat, 01 Mar 2025 12:30:00 GMT", now.Add(30 * time.Minute), true},
+		{"Saturday, 01-Mar-25 12:30:00 GMT", now.Add(30 * time.Minute), true},
+		{"", time.Time{}, false},
+		{"-5", time.Time{}, false},
+		{"soon", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		retryAfter string
+		wantRetry  bool
+	}{
+		{"too many requests", http.StatusTooManyRequests, "60", true},
+		{"unavailable", http.StatusServiceUnavailable, "Sat, 01 Mar 2099 12:30:00 GMT", true},
+		{"too many requests without Retry-After", http.StatusTooManyRequests, "", false},
+		{"not found with Retry-After", http.StatusNotFound, "60", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			if tt.retryAfter != "" {
+				recorder.Header().Set("Retry-After", tt.retryAfter)
+			}
+			recorder.WriteHeader(tt.statusCode)
+
+			err := httpStatusError(recorder.Result())
+			var retryErr retryAfterError
+			if errors.As(err, &retryErr) != tt.wantRetry {
+				t.Fatalf("httpStatusError() = %v, want a retryAfterError: %v", err, tt.wantRetry)
+			}
+			if tt.wantRetry && !retryErr.Until.After(time.Now()) {
+				t.Errorf("httpStatusError() retry at %v, want a time in the future", retryErr.Until)
+			}
+		})
+	}
+}
+
+func TestHostThrottleSweep(t *testing.T) {
+	now := time.Now()
+	throttle := &hostThrottle{
+		next:        map[string]time.Time{"past.example": now.Add(-time.Second), "future.example": now.Add(time.Minute)},
+		crawlDelays: map[string]crawlDelay{"past.example": {Delay: time.Second, Expires: now.Add(-time.Second)}},
+		blocked:     map[string]retryAfterError{"past.example": {Until: now.Add(-time.Second)}},
+	}
+
+	throttle.sweep(now)
+	if _, ok := throttle.next["past.example"]; ok {
+		t.Error("sweep() kept the next request time of a host that has passed")
+	}
+	if _, ok := throttle.next["future.example"]; !ok {
+		t.Error("sweep() evicted the next request time of a host that has not passed")
+	}
+	if len(throttle.crawlDelays) != 0 || len(throttle.blocked) != 0 {
+		t.Errorf("sweep() kept expired entries: %v, %v", throttle.crawlDelays, throttle.blocked)
+	}
+}
@@ -0,0 +1,161 @@
+package functions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/cl3mcg/speakrine/databases"
+	"github.com/cl3mcg/speakrine/types"
+	"github.com/mmcdole/gofeed"
+)
+
+// normalizeCategories trims, case-folds and deduplicates a list of categories, dropping the empty ones.
+//
+// Parameters:
+//   - categories: The categories to normalize.
+//
+// Returns:
+//   - []string: The normalized categories, in their original order.
+func normalizeCategories(categories []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, category := range categories {
+		value := strings.ToLower(strings.Join(strings.Fields(category), " "))
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		normalized = append(normalized, value)
+	}
+	return normalized
+}
+
+// itemCategories collects the categories of a feed item from its RSS <category>, Atom <category>,
+// JSON Feed tags and Dublin Core dc:subject elements.
+func itemCategories(item *gofeed.Item) []string {
+	categories := append([]string{}, item.Categories...)
+	if item.DublinCoreExt != nil {
+		categories = append(categories, item.DublinCoreExt.Subject...)
+	}
+	return normalizeCategories(categories)
+}
+
+// feedCategories collects the categories of a feed from its channel-level <category>, iTunes category
+// and Dublin Core dc:subject elements.
+func feedCategories(feed *gofeed.Feed) []string {
+	categories := append([]string{}, feed.Categories...)
+	if feed.ITunesExt != nil {
+		for _, category := range feed.ITunesExt.Categories {
+			// A podcast category may be refined by a subcategory, such as Technology > Tech News.
+			for ; category != nil; category = category.Subcategory {
+				categories = append(categories, category.Text)
+			}
+		}
+	}
+	if feed.DublinCoreExt != nil {
+		categories = append(categories, feed.DublinCoreExt.Subject...)
+	}
+	return normalizeCategories(categories)
+}
+
+// categoriesJSON encodes a list of categories for a JSON column, or returns NULL for an empty list.
+func categoriesJSON(categories []string) sql.NullString {
+	if len(categories) == 0 {
+		return sql.NullString{}
+	}
+	jsonCategories, err := json.Marshal(categories)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(jsonCategories), Valid: true}
+}
+
+// updateFeedSourceCategories stores the categories advertised by the channel of a feed.
+// They are kept apart from the categories chosen by the user, which live in rss_feeds.categories.
+//
+// Parameters:
+//   - exec: The database connection instance or transaction.
+//   - feedId: The ID of the feed to update.
+//   - feed: The fetched feed.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the feed.
+func updateFeedSourceCategories(exec sqlExecutor, feedId int, feed *gofeed.Feed) error {
+	_, err := exec.Exec("UPDATE rss_feeds SET source_categories = ? WHERE id = ?", categoriesJSON(feedCategories(feed)), feedId)
+	return err
+}
+
+// ListItemsByCategory retrieves the visible items of a user tagged with the given category, most recent first.
+//
+// Parameters:
+//   - userId: The ID of the user.
+//   - category: The category to look for, normalized the same way as the stored categories.
+//   - limit: The maximum number of items to return.
+//
+// Returns:
+//   - []types.RssItem: The matching items, with their media files but without their content.
+//   - error: An error, if any, occurred while querying the database.
+func ListItemsByCategory(userId int, category string, limit int) ([]types.RssItem, error) {
+	db := databases.GetDB()
+
+	normalized := normalizeCategories([]string{category})
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT rss_items.id, rss_items.rss_feed_id, rss_items.title, rss_items.link, rss_items.published_date, rss_items.categories, rss_items.is_read
+		FROM rss_items
+		JOIN rss_feeds ON rss_items.rss_feed_id = rss_feeds.id
+		WHERE rss_feeds.user_id = ?
+			AND rss_items.is_hidden = FALSE
+			AND rss_items.archived_at IS NULL
+			AND JSON_CONTAINS(rss_items.categories, JSON_QUOTE(?))
+		ORDER BY rss_items.published_date DESC
+		LIMIT ?
+	`
+
+	rows, err := db.Query(query, userId, normalized[0], limit)
+	if err != nil {
+		slog.Error("Error querying rss_items by category", "Category", normalized[0], "Error", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	var items []types.RssItem
+	for rows.Next() {
+		var item types.RssItem
+		var publishedDate sql.NullTime
+		var jsonCategories sql.NullString
+		if err := rows.Scan(&item.Id, &item.RssFeedId, &item.Title, &item.Link, &publishedDate, &jsonCategories, &item.IsRead); err != nil {
+			slog.Error("Error scanning rss_items row", "Error", err)
+			return nil, err
+		}
+		item.PublishedDate = publishedDate.Time
+		if jsonCategories.Valid {
+			if err := json.Unmarshal([]byte(jsonCategories.String), &item.Categories); err != nil {
+				slog.Warn("Ignoring invalid categories of item", "rss_article.id", item.Id, "Error", err)
+			}
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over rss_items rows", "Error", err)
+		return nil, err
+	}
+
+	// Load the media files of the items, so that podcast episodes and videos can be told apart from articles.
+	if err := loadItemMedia(db, items); err != nil {
+		slog.Error("Error querying rss_item_media", "Error", err)
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -2,18 +2,13 @@ package functions
 
 import (
 	"bytes"
-	"database/sql"
-	"errors"
-	"fmt"
+	"context"
 	"io"
-	"log"
 	"log/slog"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/cl3mcg/speakrine/databases"
-	"github.com/gage-technologies/mistral-go"
-	gowebly "github.com/gowebly/helpers"
 	"golang.org/x/net/html"
 )
 
@@ -291,146 +286,113 @@ func removeComments(n *html.Node) {
 	}
 }
 
-// CleanAllRSSData cleans the RSS feeds by sending raw content to Mistral AI for processing.
+// CleanAllRSSData cleans the RSS feeds by sending raw content to the LLM provider set by LLM_PROVIDER for processing.
+// The items waiting to be cleaned are those in the 'pending' state. They are claimed by batches, so that several
+// instances can clean items at the same time, and cleaned by LLM_CONCURRENCY workers, kept below LLM_RPM requests
+// and LLM_TPM tokens per minute. A chat completion failing with a 429 or 5xx status is retried up to LLM_MAX_RETRIES
+// times with a jittered backoff, and an item that fails LLM_MAX_ATTEMPTS cycles is marked failed instead of being sent
+// again at every cycle. Items without content are marked skipped.
 // It updates the database with the cleaned content.
-// It returns an error if any occurs during the process.
-func CleanAllRSSData() (err error) {
+// It returns an error if the items cannot be claimed or the LLM provider is misconfigured, the failure of a single item
+// does not stop the others.
+func CleanAllRSSData(ctx context.Context) (err error) {
 	// Get the database connection
 	db := databases.GetDB()
 
-	// Define the SQL query to fetch the necessary feed details
-	query := `
-		SELECT
-			rss_items.id,
-			rss_items.summary_raw,
-			rss_items.content_raw
-		FROM
-			rss_items
-		JOIN
-			rss_feeds ON rss_items.rss_feed_id = rss_feeds.id
-		WHERE
-				rss_items.content_formatted IS NULL
-			OR
-				LENGTH(rss_items.content_formatted) <= 10
-	`
-
-	// Execute the SQL query
-	rows, err := db.Query(query)
+	// Mark the items without content as skipped
+	skipped, err := skipEmptyItems(db)
 	if err != nil {
-		// Log and return any errors encountered while executing the query
-		slog.Error("Error executing query", "error", err)
+		slog.Error("Error skipping the RSS items without content", "error", err)
 		return err
 	}
-	// Ensure that rows are closed after processing is complete
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			// Log any errors encountered while closing the rows
-			slog.Error("Error closing the rows after query", "error", err)
-		}
-	}(rows)
-
-	// Check if there are any rows returned
-	if !rows.Next() {
-		// No rows returned
-		slog.Info("No new RSS items to clean: No rows returned from the query")
-		return nil
-	}
-
-	// Retrieve the Mistral AI API key from the environment variables
-	mistralApiKey := gowebly.Getenv("MISTRAL_API_KEY", "")
-	if mistralApiKey == "" {
-		// Log an error if the Mistral AI API key is not set
-		slog.Error("Missing required environment variable", "details", "Mistral AI API key environment variable is missing")
-		return errors.New("mistral API key is not set as an environment variable")
-	}
-
-	// Retrieve the Mistral AI model name from the environment variables
-	mistralModel := gowebly.Getenv("MISTRAL_MODEL_TINY", "")
-	if mistralModel == "" {
-		// Log an error if the Mistral AI model name is not set
-		slog.Error("Missing required environment variable", "details", "Mistral AI model name environment variable is missing")
-		return errors.New("mistral model is not set as an environment variable")
+	if skipped > 0 {
+		slog.Info("Bypassing the cleaning of articles with an empty content", "Items", skipped)
 	}
 
-	// Retrieve the Mistral AI text prompt used to instruct the model
-	// Define the file path
-	filePath := "assets/prompt/speakrine_prompt_clean_article_content_v1.txt"
-
-	// Read the file content
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
+	// The items failing during this run are left for the next one
+	var runStart time.Time
+	if err := db.QueryRow("SELECT NOW()").Scan(&runStart); err != nil {
+		slog.Error("Error executing query", "error", err)
+		return err
 	}
 
-	// Convert the content to a string
-	mistralPrompt := string(content)
-
-	// Process the rows and convert them into RssFeed objects
-	for rows.Next() {
-		var articleId int
-		var articleRawSummary sql.NullString
-		var articleRawContent sql.NullString
+	owner := cleaningInstanceId()
+	concurrency := getenvInt("LLM_CONCURRENCY", 2)
+	batchSize := max(getenvInt("LLM_BATCH_SIZE", 50), concurrency)
+	claimTimeout := time.Duration(getenvInt("LLM_CLAIM_TIMEOUT", 3600)) * time.Second
 
-		err := rows.Scan(&articleId, &articleRawSummary, &articleRawContent)
-		if err != nil {
-			slog.Error("Error scanning row", "error", err)
-			return err
+	// Hand back the items that could not be cleaned before the process stopped
+	defer func() {
+		if err := releaseCleaningItems(db, owner); err != nil {
+			slog.Error("Error releasing the claimed RSS items", "error", err)
 		}
+	}()
 
-		// Check if both articleCleanSummary and articleCleanContent are not NULL and not empty
-		if !articleRawContent.Valid || len(articleRawContent.String) <= 10 {
-			slog.Info("Bypassing the cleaning of article with an empty content", "rss_article.id", articleId)
-			continue
-		}
-
-		// Here call the cleanHTMLContent function to clean the articleRawContent
-		// The output of the cleanHTMLContent function is the input of the Mistral AI model
-		cleanedContent, err := cleanHTMLContent(articleRawContent.String)
+	var settings cleaningSettings
+	total, cleaned, failed := 0, 0, 0
+	for ctx.Err() == nil {
+		items, err := claimCleaningItems(db, owner, batchSize, claimTimeout, runStart)
 		if err != nil {
-			slog.Error("Error cleaning HTML content", "error", err)
+			slog.Error("Error claiming the RSS items to clean", "error", err)
 			return err
 		}
-
-		// Define the Mistral AI client
-		client := mistral.NewMistralClientDefault(mistralApiKey)
-
-		// Using Chat Completions
-		mistralQuery, err := client.Chat(mistralModel, []mistral.ChatMessage{{Content: fmt.Sprintf("%v \n %v", mistralPrompt, cleanedContent), Role: mistral.RoleUser}}, nil)
-		if err != nil {
-			slog.Error("Error retrieving the chat completion from Mistral AI", "error", err)
-			return err
+		if len(items) == 0 {
+			break
 		}
 
-		mistralResponse := mistralQuery.Choices[0].Message.Content
-
-		mistralOutputCleaned, err := cleanHTMLContent(mistralResponse)
-		if err != nil {
-			slog.Error("Error cleaning HTML content", "error", err)
-			return err
+		// Build the LLM provider selected by the environment variables, once there is something to clean
+		if settings.Provider == nil {
+			settings, err = loadCleaningSettings(owner)
+			if err != nil {
+				return err
+			}
 		}
 
-		// Update the database with the cleaned content
-		updateQuery := `
-			UPDATE rss_items
-			SET content_formatted = ?
-			WHERE id = ?
-		`
+		batchCleaned, batchFailed := cleanItemsConcurrently(ctx, db, settings, items, concurrency)
+		total, cleaned, failed = total+len(items), cleaned+batchCleaned, failed+batchFailed
+	}
 
-		_, err = db.Exec(updateQuery, mistralOutputCleaned, articleId)
-		if err != nil {
-			slog.Error("Error updating the database with cleaned content", "error", err)
-			return err
-		}
+	if total == 0 {
+		slog.Info("No new RSS items to clean: No rows returned from the query")
+		return nil
+	}
+	slog.Info("RSS items cleaned", "Provider", settings.Provider.Name(), "Items", total, "Cleaned", cleaned, "Failed", failed)
+	return nil
+}
 
-		slog.Info("An article content has been cleaned", "rss_article.id", articleId)
+// loadCleaningSettings builds the LLM provider and reads the prompt and the limits of the cleaning workers.
+//
+// Parameters:
+//   - owner: The identifier the current instance claims items with.
+//
+// Returns:
+//   - cleaningSettings: The settings of the cleaning workers.
+//   - error: An error, if the LLM provider is misconfigured or the prompt cannot be loaded.
+func loadCleaningSettings(owner string) (cleaningSettings, error) {
+	llm := loadLLMConfig()
+	provider, err := newLLMProvider(llm)
+	if err != nil {
+		// Log an error if the provider cannot be built
+		slog.Error("Invalid LLM configuration", "details", err)
+		return cleaningSettings{}, err
 	}
 
-	// Check for any errors encountered during iteration
-	if err = rows.Err(); err != nil {
-		slog.Error("Error iterating over rows", "error", err)
-		return err
+	// Retrieve the text prompt used to instruct the model
+	cleaning, err := cleaningPrompt()
+	if err != nil {
+		slog.Error("Error loading the cleaning prompt", "details", err)
+		return cleaningSettings{}, err
 	}
 
-	return nil
+	return cleaningSettings{
+		Owner:         owner,
+		Provider:      provider,
+		Config:        llm,
+		Prompt:        cleaning.Text,
+		PromptVersion: cleaning.VersionLabel(),
+		Limiter:       newLLMRateLimiter(getenvNonNegativeInt("LLM_RPM", 0), getenvNonNegativeInt("LLM_TPM", 0)),
+		MaxRetries:    getenvNonNegativeInt("LLM_MAX_RETRIES", 3),
+		MaxAttempts:   getenvInt("LLM_MAX_ATTEMPTS", 5),
+		Fidelity:      loadFidelitySettings(),
+	}, nil
 }
@@ -0,0 +1,160 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The cleaning states of an item, stored in rss_items.clean_status.
+const (
+	cleanStatusPending  = "pending"  // The item waits to be cleaned.
+	cleanStatusCleaning = "cleaning" // The item is being cleaned by the instance set in clean_claimed_by.
+	cleanStatusCleaned  = "cleaned"  // The item has been cleaned, its content is in content_formatted.
+	cleanStatusSkipped  = "skipped"  // The item has no content to clean.
+	cleanStatusFailed   = "failed"   // The cleaning of the item failed too many times, it is no longer cleaned.
+)
+
+// cleaningInstanceId returns the identifier the current process claims items with, made of its host name and process ID.
+func cleaningInstanceId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%v:%d", hostname, os.Getpid())
+}
+
+// skipEmptyItems marks the pending items without content as skipped, so that they are no longer selected for cleaning.
+//
+// Parameters:
+//   - db: The database connection instance.
+//
+// Returns:
+//   - int64: The number of items skipped.
+//   - error: An error, if any, occurred while updating the database.
+func skipEmptyItems(db *sql.DB) (int64, error) {
+	query := `
+		UPDATE rss_items
+		SET clean_status = ?, clean_finished_at = NOW()
+		WHERE clean_status = ? AND (content_raw IS NULL OR LENGTH(content_raw) <= 10)
+	`
+	res, err := db.Exec(query, cleanStatusSkipped, cleanStatusPending)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// claimCleaningItems claims a batch of items to clean for the current instance. The rows are locked with
+// SKIP LOCKED, so that several instances claim different items. The items claimed by an instance that did not
+// finish cleaning them within claimTimeout, because it crashed, are claimed again. The items whose last cleaning
+// ended after runStart are left for the next run, so that a failing item is tried once per run.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - owner: The identifier of the current instance.
+//   - limit: The maximum number of items to claim.
+//   - claimTimeout: The duration after which an item still being cleaned is considered abandoned.
+//   - runStart: The database time at which the current run started.
+//
+// Returns:
+//   - []cleaningItem: The claimed items, whose state is now 'cleaning'.
+//   - error: An error, if any, occurred while claiming the items, in which case nothing was claimed.
+func claimCleaningItems(db *sql.DB, owner string, limit int, claimTimeout time.Duration, runStart time.Time) ([]cleaningItem, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	query := `
+		SELECT id
+		FROM rss_items
+		WHERE archived_at IS NULL
+			AND (
+				(clean_status = ? AND (clean_finished_at IS NULL OR clean_finished_at < ?))
+				OR (clean_status = ? AND clean_started_at < NOW() - INTERVAL ? SECOND)
+			)
+		ORDER BY id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.Query(query, cleanStatusPending, runStart, cleanStatusCleaning, int(claimTimeout.Seconds()), limit)
+	if err != nil {
+		return nil, err
+	}
+	var args []any
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		args = append(args, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	placeholders := "?" + strings.Repeat(", ?", len(args)-1)
+	claimQuery := `
+		UPDATE rss_items
+		SET clean_status = ?, clean_claimed_by = ?, clean_started_at = NOW()
+		WHERE id IN (` + placeholders + `)`
+	if _, err := tx.Exec(claimQuery, append([]any{cleanStatusCleaning, owner}, args...)...); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query("SELECT id, content_raw, clean_attempts FROM rss_items WHERE id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	var items []cleaningItem
+	for rows.Next() {
+		var item cleaningItem
+		var rawContent sql.NullString
+		if err := rows.Scan(&item.Id, &rawContent, &item.Attempts); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		item.RawContent = rawContent.String
+		items = append(items, item)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, tx.Commit()
+}
+
+// releaseCleaningItems hands back the items claimed by the current instance that it did not clean,
+// because the run was interrupted, so that any instance can claim them again.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - owner: The identifier of the current instance.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the database.
+func releaseCleaningItems(db *sql.DB, owner string) error {
+	query := `
+		UPDATE rss_items
+		SET clean_status = ?, clean_claimed_by = NULL, clean_started_at = NULL
+		WHERE clean_status = ? AND clean_claimed_by = ?
+	`
+	_, err := db.Exec(query, cleanStatusPending, cleanStatusCleaning, owner)
+	return err
+}
@@ -0,0 +1,250 @@
+package functions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cleaningItem holds an item waiting to be cleaned by the LLM.
+type cleaningItem struct {
+	Id         int    // The ID of the item.
+	RawContent string // The raw HTML content of the item.
+	Attempts   int    // The number of cleaning cycles that already failed for the item.
+}
+
+// cleaningSettings holds what the cleaning workers share.
+type cleaningSettings struct {
+	Owner         string           // The identifier the current instance claims items with.
+	Provider      LLMProvider      // The LLM provider the items are sent to.
+	Config        llmConfig        // The model and sampling parameters of the chat completions.
+	Prompt        string           // The prompt instructing the model how to clean an article.
+	PromptVersion string           // The version of the prompt, recorded with each cleaned item.
+	Limiter       *llmRateLimiter  // The limiter keeping the requests below LLM_RPM and LLM_TPM.
+	MaxRetries    int              // The number of times a chat completion is retried within a cycle.
+	MaxAttempts   int              // The number of failed cycles after which an item is marked failed.
+	Fidelity      fidelitySettings // The thresholds the outputs of the model are verified against.
+}
+
+// cleaningOutcome holds the content of a cleaned item.
+type cleaningOutcome struct {
+	Content       string // The cleaned content of the item.
+	Model         string // The model that cleaned the item, empty when the deterministic cleaner output was kept.
+	NeedsReview   bool   // Whether the content may not be faithful to the article and should be reviewed.
+	FidelityIssue string // Why the output of the model was found unfaithful to the article, if it was.
+}
+
+// cleaningInputSeparator separates the prompt, which ends with "INPUT TO CLEAN:", from the article in the message sent to the model.
+const cleaningInputSeparator = " \n "
+
+// requestCleaning asks the model to clean an article. The chat completion is retried with a jittered
+// exponential backoff when the provider rate limits the requests or fails, up to MaxRetries times.
+//
+// Parameters:
+//   - ctx: The context of the run, cancelling it aborts the request.
+//   - settings: The settings of the cleaning workers.
+//   - item: The item to clean.
+//   - article: The content of the item, as cleaned by cleanHTMLContent.
+//
+// Returns:
+//   - string: The output of the model, cleaned by cleanHTMLContent.
+//   - string: The model that cleaned the item.
+//   - error: The error of the last attempt, if the model could not clean the item.
+func requestCleaning(ctx context.Context, settings cleaningSettings, item cleaningItem, article string) (string, string, error) {
+	// Using Chat Completions, the article following the prompt in a single user message
+	messages := []LLMMessage{{Role: llmRoleUser, Content: settings.Prompt + cleaningInputSeparator + article}}
+	// The completion is about as long as the article, so it is counted twice.
+	estimated := estimateLLMTokens(settings.Prompt) + 2*estimateLLMTokens(article)
+
+	for attempt := 1; ; attempt++ {
+		if err := settings.Limiter.wait(ctx, estimated); err != nil {
+			return "", "", err
+		}
+		response, err := settings.Provider.Chat(ctx, settings.Config.Model, messages, settings.Config.Params)
+		if err == nil {
+			settings.Limiter.record(estimated, response.PromptTokens+response.CompletionTokens)
+			model := response.Model
+			if model == "" {
+				model = settings.Config.Model
+			}
+			content, err := cleanHTMLContent(response.Content)
+			if err == nil && strings.TrimSpace(content) == "" {
+				err = errors.New("the model answered with an empty content")
+			}
+			return content, model, err
+		}
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+		if !isRetryableLLMError(err) || attempt > settings.MaxRetries {
+			return "", "", err
+		}
+
+		backoff := llmBackoff(attempt, err)
+		slog.Warn("Retrying the chat completion", "Provider", settings.Provider.Name(), "Item ID", item.Id, "Attempt", attempt, "Backoff", backoff, "Error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// cleanItemContent cleans the content of an item with the LLM, then verifies that the output of the model is faithful
+// to the article. An unfaithful output is requested again up to FIDELITY_RETRIES times, then either stored as is or
+// replaced by the output of cleanHTMLContent, according to FIDELITY_FALLBACK, and flagged as needing a review.
+//
+// Parameters:
+//   - ctx: The context of the run, cancelling it aborts the cleaning.
+//   - settings: The settings of the cleaning workers.
+//   - item: The item to clean.
+//
+// Returns:
+//   - cleaningOutcome: The cleaned content of the item.
+//   - error: An error, if the item could not be cleaned.
+func cleanItemContent(ctx context.Context, settings cleaningSettings, item cleaningItem) (cleaningOutcome, error) {
+	// The output of the cleanHTMLContent function is the input of the LLM
+	article, err := cleanHTMLContent(item.RawContent)
+	if err != nil {
+		return cleaningOutcome{}, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		content, model, err := requestCleaning(ctx, settings, item, article)
+		if err != nil {
+			return cleaningOutcome{}, err
+		}
+		outcome := cleaningOutcome{Content: content, Model: model}
+		if !settings.Fidelity.Enabled {
+			return outcome, nil
+		}
+
+		report := verifyFidelity(article, content)
+		issues := report.issues(settings.Fidelity)
+		if len(issues) == 0 {
+			return outcome, nil
+		}
+		outcome.FidelityIssue = strings.Join(issues, "; ")
+		slog.Warn("The cleaned article is not faithful to the original", "Item ID", item.Id, "Model", model, "Attempt", attempt+1, "Issues", outcome.FidelityIssue)
+		if attempt < settings.Fidelity.Retries {
+			continue
+		}
+
+		outcome.NeedsReview = true
+		if settings.Fidelity.Fallback == fidelityFallbackDeterministic {
+			outcome.Content, outcome.Model = article, ""
+		}
+		return outcome, nil
+	}
+}
+
+// storeCleaningOutcome stores the cleaned content of an item along with the model and prompt version used, or records
+// its failure. A failed item goes back to the pending state, unless it failed MaxAttempts cycles, in which case it is
+// marked failed so that it is no longer sent to the LLM at every cycle. The item is only updated if the current instance
+// still holds its claim.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - settings: The settings of the cleaning workers.
+//   - item: The item that was cleaned.
+//   - outcome: The cleaned content of the item.
+//   - cleanErr: The error, if any, occurred while cleaning the item.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the database.
+func storeCleaningOutcome(db *sql.DB, settings cleaningSettings, item cleaningItem, outcome cleaningOutcome, cleanErr error) error {
+	if cleanErr == nil {
+		query := `
+			UPDATE rss_items
+			SET content_formatted = ?, clean_status = ?, clean_error = NULL, clean_model = NULLIF(?, ''), clean_prompt_version = ?,
+				needs_review = ?, fidelity_issue = NULLIF(?, ''), clean_claimed_by = NULL, clean_finished_at = NOW()
+			WHERE id = ? AND clean_claimed_by = ?
+		`
+		_, err := db.Exec(query, outcome.Content, cleanStatusCleaned, outcome.Model, settings.PromptVersion,
+			outcome.NeedsReview, outcome.FidelityIssue, item.Id, settings.Owner)
+		if err == nil {
+			slog.Info("An article content has been cleaned", "Item ID", item.Id, "Model", outcome.Model, "Needs review", outcome.NeedsReview)
+		}
+		return err
+	}
+
+	attempts := item.Attempts + 1
+	status := cleanStatusPending
+	if attempts >= settings.MaxAttempts {
+		status = cleanStatusFailed
+		slog.Error("Giving up on cleaning an article", "Item ID", item.Id, "Attempts", attempts, "Error", cleanErr)
+	} else {
+		slog.Warn("Error cleaning an article, it will be retried at the next cycle", "Item ID", item.Id, "Attempts", attempts, "Error", cleanErr)
+	}
+	query := `
+		UPDATE rss_items
+		SET clean_status = ?, clean_attempts = ?, clean_error = ?, clean_claimed_by = NULL, clean_finished_at = NOW()
+		WHERE id = ? AND clean_claimed_by = ?
+	`
+	_, err := db.Exec(query, status, attempts, cleanErr.Error(), item.Id, settings.Owner)
+	return err
+}
+
+// cleanItemsConcurrently cleans the given items with a bounded pool of workers sharing a rate limiter.
+// The failure of an item is recorded and does not stop the other items. When the context is cancelled,
+// no new item is handed to the workers and the items left are still claimed, to be released by the caller.
+//
+// Parameters:
+//   - ctx: The context of the run.
+//   - db: The database connection instance.
+//   - settings: The settings of the cleaning workers.
+//   - items: The items to clean.
+//   - concurrency: The number of cleaning workers.
+//
+// Returns:
+//   - int: The number of items cleaned.
+//   - int: The number of items that could not be cleaned.
+func cleanItemsConcurrently(ctx context.Context, db *sql.DB, settings cleaningSettings, items []cleaningItem, concurrency int) (int, int) {
+	jobs := make(chan cleaningItem)
+	var mu sync.Mutex
+	cleaned, failed := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				outcome, cleanErr := cleanItemContent(ctx, settings, item)
+				if errors.Is(cleanErr, context.Canceled) || ctx.Err() != nil {
+					continue
+				}
+				if err := storeCleaningOutcome(db, settings, item, outcome, cleanErr); err != nil {
+					slog.Error("Error updating the database with the cleaning outcome", "Item ID", item.Id, "Error", err)
+				}
+
+				mu.Lock()
+				if cleanErr == nil {
+					cleaned++
+				} else {
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- item:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return cleaned, failed
+}
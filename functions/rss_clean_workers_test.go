@@ -0,0 +1,182 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// scriptedLLMProvider answers the chat completions with the given responses and errors in turn, the last one being
+// repeated, and counts the requests it received.
+type scriptedLLMProvider struct {
+	answers []scriptedLLMAnswer
+	calls   int
+}
+
+type scriptedLLMAnswer struct {
+	Content string
+	Err     error
+}
+
+func (p *scriptedLLMProvider) Name() string {
+	return "scripted"
+}
+
+func (p *scriptedLLMProvider) Chat(_ context.Context, model string, _ []LLMMessage, _ LLMParams) (*LLMResponse, error) {
+	answer := p.answers[min(p.calls, len(p.answers)-1)]
+	p.calls++
+	if answer.Err != nil {
+		return nil, answer.Err
+	}
+	return &LLMResponse{Content: answer.Content, Model: model}, nil
+}
+
+const testArticle = `<p>The city council approved the new budget on Tuesday after a long debate about public transport.</p>
+<p>The mayor said the investment in buses and tramways would be the largest in a decade.</p>
+<p>Opposition members criticised the lack of funding for the renovation of schools.</p>`
+
+func testCleaningSettings(provider LLMProvider) cleaningSettings {
+	return cleaningSettings{
+		Owner:         "test",
+		Provider:      provider,
+		Config:        llmConfig{Provider: provider.Name(), Model: "test-model"},
+		Prompt:        "Clean the following article.\nINPUT TO CLEAN:",
+		PromptVersion: "test",
+		Limiter:       newLLMRateLimiter(0, 0),
+		MaxRetries:    2,
+		MaxAttempts:   3,
+		Fidelity: fidelitySettings{
+			Enabled:              true,
+			MinRecall:            0.8,
+			MinPrecision:         0.9,
+			MaxDroppedParagraphs: 0,
+			Retries:              1,
+			Fallback:             fidelityFallbackReview,
+		},
+	}
+}
+
+func TestRequestCleaningWithFakeProvider(t *testing.T) {
+	settings := testCleaningSettings(fakeLLMProvider{})
+	article, err := cleanHTMLContent(testArticle)
+	if err != nil {
+		t.Fatalf("cleanHTMLContent() error = %v", err)
+	}
+
+	content, model, err := requestCleaning(context.Background(), settings, cleaningItem{Id: 1}, article)
+	if err != nil {
+		t.Fatalf("requestCleaning() error = %v", err)
+	}
+	if model != "test-model" {
+		t.Errorf("requestCleaning() model = %q, want %q", model, "test-model")
+	}
+	if content != article {
+		t.Errorf("requestCleaning() content = %q, want the article %q", content, article)
+	}
+}
+
+func TestRequestCleaningErrors(t *testing.T) {
+	t.Run("not retryable", func(t *testing.T) {
+		provider := &scriptedLLMProvider{answers: []scriptedLLMAnswer{
+			{Err: &llmStatusError{Provider: "scripted", StatusCode: http.StatusBadRequest, Message: "bad request"}},
+		}}
+		_, _, err := requestCleaning(context.Background(), testCleaningSettings(provider), cleaningItem{Id: 1}, "<p>Article</p>")
+		var statusErr *llmStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("requestCleaning() error = %v, want the HTTP 400 error", err)
+		}
+		if provider.calls != 1 {
+			t.Errorf("requestCleaning() sent %d requests, want 1", provider.calls)
+		}
+	})
+
+	t.Run("empty answer", func(t *testing.T) {
+		provider := &scriptedLLMProvider{answers: []scriptedLLMAnswer{{Content: "  "}}}
+		if _, _, err := requestCleaning(context.Background(), testCleaningSettings(provider), cleaningItem{Id: 1}, "<p>Article</p>"); err == nil {
+			t.Error("requestCleaning() error = nil, want an error for an empty answer")
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err := requestCleaning(ctx, testCleaningSettings(fakeLLMProvider{}), cleaningItem{Id: 1}, "<p>Article</p>")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("requestCleaning() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestCleanItemContent(t *testing.T) {
+	unfaithful := "<p>The city council approved the new budget.</p>"
+
+	t.Run("faithful output", func(t *testing.T) {
+		outcome, err := cleanItemContent(context.Background(), testCleaningSettings(fakeLLMProvider{}), cleaningItem{Id: 1, RawContent: testArticle})
+		if err != nil {
+			t.Fatalf("cleanItemContent() error = %v", err)
+		}
+		if outcome.NeedsReview || outcome.FidelityIssue != "" {
+			t.Errorf("cleanItemContent() flagged a faithful output: %+v", outcome)
+		}
+		if outcome.Model != "test-model" || !strings.Contains(outcome.Content, "largest in a decade") {
+			t.Errorf("cleanItemContent() outcome = %+v, want the article cleaned by test-model", outcome)
+		}
+	})
+
+	t.Run("unfaithful output then faithful", func(t *testing.T) {
+		provider := &scriptedLLMProvider{answers: []scriptedLLMAnswer{{Content: unfaithful}, {Content: testArticle}}}
+		outcome, err := cleanItemContent(context.Background(), testCleaningSettings(provider), cleaningItem{Id: 1, RawContent: testArticle})
+		if err != nil {
+			t.Fatalf("cleanItemContent() error = %v", err)
+		}
+		if provider.calls != 2 {
+			t.Errorf("cleanItemContent() sent %d requests, want 2", provider.calls)
+		}
+		if outcome.NeedsReview || outcome.FidelityIssue != "" {
+			t.Errorf("cleanItemContent() flagged the faithful retry: %+v", outcome)
+		}
+	})
+
+	t.Run("review fallback", func(t *testing.T) {
+		provider := &scriptedLLMProvider{answers: []scriptedLLMAnswer{{Content: unfaithful}}}
+		outcome, err := cleanItemContent(context.Background(), testCleaningSettings(provider), cleaningItem{Id: 1, RawContent: testArticle})
+		if err != nil {
+			t.Fatalf("cleanItemContent() error = %v", err)
+		}
+		if !outcome.NeedsReview || outcome.FidelityIssue == "" {
+			t.Errorf("cleanItemContent() did not flag an unfaithful output: %+v", outcome)
+		}
+		if outcome.Model != "test-model" || strings.Contains(outcome.Content, "largest in a decade") {
+			t.Errorf("cleanItemContent() outcome = %+v, want the output of the model", outcome)
+		}
+	})
+
+	t.Run("deterministic fallback", func(t *testing.T) {
+		provider := &scriptedLLMProvider{answers: []scriptedLLMAnswer{{Content: unfaithful}}}
+		settings := testCleaningSettings(provider)
+		settings.Fidelity.Fallback = fidelityFallbackDeterministic
+		outcome, err := cleanItemContent(context.Background(), settings, cleaningItem{Id: 1, RawContent: testArticle})
+		if err != nil {
+			t.Fatalf("cleanItemContent() error = %v", err)
+		}
+		article, _ := cleanHTMLContent(testArticle)
+		if !outcome.NeedsReview || outcome.Model != "" || outcome.Content != article {
+			t.Errorf("cleanItemContent() outcome = %+v, want the deterministic output flagged for review", outcome)
+		}
+	})
+
+	t.Run("fidelity check disabled", func(t *testing.T) {
+		provider := &scriptedLLMProvider{answers: []scriptedLLMAnswer{{Content: unfaithful}}}
+		settings := testCleaningSettings(provider)
+		settings.Fidelity.Enabled = false
+		outcome, err := cleanItemContent(context.Background(), settings, cleaningItem{Id: 1, RawContent: testArticle})
+		if err != nil {
+			t.Fatalf("cleanItemContent() error = %v", err)
+		}
+		if outcome.NeedsReview || provider.calls != 1 {
+			t.Errorf("cleanItemContent() outcome = %+v after %d requests, want the output of the model unverified", outcome, provider.calls)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/cl3mcg/speakrine/databases"
+	"github.com/cl3mcg/speakrine/types"
+	"golang.org/x/net/html"
+)
+
+// feedLinkTypes maps the MIME types of <link rel="alternate"> elements to the feed types they announce.
+var feedLinkTypes = map[string]string{
+	"application/rss+xml":   "rss",
+	"application/atom+xml":  "atom",
+	"application/feed+json": "json",
+	"application/json":      "json",
+}
+
+// commonFeedPaths are the paths tried on the site of a page when looking for feeds it does not announce.
+var commonFeedPaths = []string{"/feed", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml", "/rss", "/feed.json"}
+
+// findFeedLinks parses an HTML page and returns the absolute URLs of the feeds announced by its
+// <link rel="alternate"> elements, relative URLs being resolved against the page URL or its <base href>.
+//
+// Parameters:
+//   - page: The HTML content of the page.
+//   - pageUrl: The URL of the page.
+//
+// Returns:
+//   - []string: The URLs of the announced feeds, in document order.
+func findFeedLinks(page []byte, pageUrl *url.URL) []string {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil
+	}
+
+	base := pageUrl
+	var hrefs []string
+	var traverse func(n *html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "base" || n.Data == "link") {
+			attrs := make(map[string]string)
+			for _, attr := range n.Attr {
+				attrs[strings.ToLower(attr.Key)] = strings.TrimSpace(attr.Val)
+			}
+			if n.Data == "base" && attrs["href"] != "" {
+				if baseUrl, err := pageUrl.Parse(attrs["href"]); err == nil {
+					base = baseUrl
+				}
+			}
+			rels := strings.Fields(strings.ToLower(attrs["rel"]))
+			mimeType := strings.ToLower(strings.TrimSpace(strings.Split(attrs["type"], ";")[0]))
+			if n.Data == "link" && attrs["href"] != "" && contains(rels, "alternate") && feedLinkTypes[mimeType] != "" {
+				hrefs = append(hrefs, attrs["href"])
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(doc)
+
+	links := make([]string, 0, len(hrefs))
+	for _, href := range hrefs {
+		if linkUrl, err := base.Parse(href); err == nil {
+			links = append(links, linkUrl.String())
+		}
+	}
+	return links
+}
+
+// contains reports whether the slice contains the given value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFeedCandidate downloads and parses a candidate feed URL.
+//
+// Parameters:
+//   - ctx: The context of the discovery.
+//   - feedUrl: The URL of the candidate feed.
+//
+// Returns:
+//   - types.FeedCandidate: The candidate with its title and detected type.
+//   - bool: Whether the URL points to a feed gofeed can parse.
+func validateFeedCandidate(ctx context.Context, feedUrl string) (types.FeedCandidate, bool) {
+	body, finalUrl, err := fetchPage(ctx, feedUrl)
+	if err != nil {
+		return types.FeedCandidate{}, false
+	}
+	feed, _, err := parseFeedBody(body, "")
+	if err != nil {
+		return types.FeedCandidate{}, false
+	}
+	return types.FeedCandidate{Url: finalUrl, Title: strings.TrimSpace(feed.Title), FeedType: feed.FeedType}, true
+}
+
+// DiscoverFeeds finds the feeds of a website from the URL of any of its pages.
+// If the URL is itself a feed, it is returned as the only candidate. Otherwise the feeds announced by the
+// <link rel="alternate"> elements of the page are tried first, then the common feed paths of the site
+// (/feed, /rss.xml, /atom.xml...). Every candidate is validated by parsing it with gofeed.
+//
+// Parameters:
+//   - ctx: The context of the discovery, cancelling it aborts the downloads.
+//   - pageUrl: The URL of a page of the website.
+//
+// Returns:
+//   - []types.FeedCandidate: The valid feeds found, with their title and detected type.
+//   - error: An error, if any, occurred while downloading the page.
+func DiscoverFeeds(ctx context.Context, pageUrl string) ([]types.FeedCandidate, error) {
+	page, finalUrl, err := fetchPage(ctx, pageUrl)
+	if err != nil {
+		slog.Error("Error downloading page for feed discovery", "Page URL", pageUrl, "Error", err)
+		return nil, err
+	}
+
+	// The URL may already be a feed.
+	if feed, _, err := parseFeedBody(page, ""); err == nil {
+		return []types.FeedCandidate{{Url: finalUrl, Title: strings.TrimSpace(feed.Title), FeedType: feed.FeedType}}, nil
+	}
+
+	parsedUrl, err := url.Parse(finalUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	links := findFeedLinks(page, parsedUrl)
+	for _, path := range commonFeedPaths {
+		links = append(links, (&url.URL{Scheme: parsedUrl.Scheme, Host: parsedUrl.Host, Path: path}).String())
+	}
+
+	var candidates []types.FeedCandidate
+	seen := make(map[string]bool)
+	for _, link := range links {
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+
+		candidate, ok := validateFeedCandidate(ctx, link)
+		if !ok || seen[candidate.Url] && candidate.Url != link {
+			continue
+		}
+		seen[candidate.Url] = true
+		if candidate.Title == "" {
+			candidate.Title = parsedUrl.Hostname()
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// SubscribeFeed creates the rss_feeds row of a discovered feed for the given user.
+//
+// Parameters:
+//   - userId: The ID of the user to subscribe.
+//   - candidate: The discovered feed.
+//
+// Returns:
+//   - bool: Whether the feed was created, false if the user was already subscribed to it.
+//   - error: An error, if any, occurred while inserting the feed.
+func SubscribeFeed(userId int, candidate types.FeedCandidate) (bool, error) {
+	db := databases.GetDB()
+
+	query := `
+		INSERT INTO rss_feeds (user_id, common_name, type, url)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = id
+	`
+
+	feedType := candidate.FeedType
+	if feedType == "" {
+		feedType = "other"
+	}
+	res, err := db.Exec(query, userId, candidate.Title, feedType, candidate.Url)
+	if err != nil {
+		slog.Error("Error inserting rss_feeds row", "Feed URL", candidate.Url, "Error", err)
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
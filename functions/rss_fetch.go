@@ -1,143 +1,530 @@
 package functions
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cl3mcg/speakrine/databases"
+	"github.com/cl3mcg/speakrine/types"
 	"github.com/mmcdole/gofeed"
 )
 
-// fetchRSSFeed fetches the RSS feed from the provided URL.
-// It returns a parsed *gofeed.Feed and an error if any occurs during the fetching or parsing process.
+// fetchResult holds the outcome of a conditional fetch of an RSS feed.
+type fetchResult struct {
+	Feed            *gofeed.Feed  // The parsed feed, nil when the server answered 304 Not Modified.
+	ETag            string        // The ETag header to send on the next fetch.
+	LastModified    string        // The Last-Modified header to send on the next fetch.
+	NotModified     bool          // Whether the server answered 304 Not Modified.
+	StatusCode      int           // The HTTP status code of the response.
+	PermanentUrl    string        // The URL the feed is permanently redirected to, or an empty string.
+	IconUrl         string        // The URL of the favicon of the website of the feed, when it was looked up.
+	IconChecked     bool          // Whether the favicon was looked up during this fetch.
+	HubUrl          string        // The WebSub hub advertised by the Link headers of the response.
+	TopicUrl        string        // The WebSub topic advertised by the Link headers of the response.
+	Repairs         []string      // The repairs applied to the document before it could be parsed.
+	DefaultInterval time.Duration // The polling interval when the items have no date to estimate the cadence from, or 0.
+}
+
+// fetchRSSFeed fetches the RSS feed from the provided URL using a conditional HTTP request.
+// The stored ETag and Last-Modified values are sent as If-None-Match and If-Modified-Since headers,
+// so that an unchanged feed is answered with a 304 Not Modified and does not have to be downloaded again.
+// Redirects are followed, and a 410 Gone is reported as errFeedGone. The credentials of a private feed
+// are sent along, and the body is read up to FETCH_MAX_BODY_SIZE bytes, then transcoded to UTF-8 and repaired
+// if it is not well-formed.
 //
 // Parameters:
-//   - url: The URL of the RSS feed to fetch.
+//   - ctx: The context of the fetch, cancelling it aborts the request.
+//   - source: The feed to fetch, with the caching headers returned by the previous fetch and its credentials.
 //
 // Returns:
-//   - *gofeed.Feed: The parsed RSS feed.
+//   - *fetchResult: The parsed RSS feed along with the caching headers to store for the next fetch.
 //   - error: An error, if any, occurred while fetching or parsing the feed.
-func fetchRSSFeed(url string) (*gofeed.Feed, error) {
-	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL(url)
+func fetchRSSFeed(ctx context.Context, source feedSource) (*fetchResult, error) {
+	url, etag, lastModified := source.Url, source.ETag, source.LastModified
+	host := feedHost(url)
+	resp, permanentUrl, err := doFeedRequest(url, func(url string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", httpSettings().UserAgent)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+		source.Credentials.apply(req, host)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			slog.Error("Error closing response body", "Feed URL", url, "Error", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, errFeedGone
+	}
+
+	// Keep the previous caching headers unless the server provides new ones.
+	result := &fetchResult{ETag: etag, LastModified: lastModified, StatusCode: resp.StatusCode, PermanentUrl: permanentUrl}
+	if value := resp.Header.Get("ETag"); value != "" {
+		result.ETag = value
+	}
+	if value := resp.Header.Get("Last-Modified"); value != "" {
+		result.LastModified = value
+	}
+	links := parseLinkHeader(resp.Header.Values("Link"), resp.Request.URL)
+	result.HubUrl, result.TopicUrl = links["hub"], links["self"]
+
+	if resp.StatusCode == http.StatusNotModified {
+		result.NotModified = true
+		return result, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, httpStatusError(resp)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, httpSettings().MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	feed, repairs, err := parseFeedBody(body, resp.Header.Get("Content-Type"))
 	if err != nil {
 		return nil, err
 	}
-	return feed, nil
+	result.Feed, result.Repairs = feed, repairs
+	return result, nil
 }
 
-// isItemInDatabase checks if an RSS item with the given GUID already exists in the database.
-// It returns true if the item exists, false if it doesn't, and an error if there was an issue querying the database.
+// fetchFeedSource fetches a feed stored in the rss_feeds table according to its type.
+// Feeds of type 'scrap' are scraped with their CSS selectors, any other feed is parsed with gofeed.
 //
 // Parameters:
-//   - db: The database connection instance.
-//   - guid: The GUID of the RSS item to check.
+//   - ctx: The context of the fetch, cancelling it aborts the request.
+//   - source: The feed to fetch.
 //
 // Returns:
-//   - bool: A boolean indicating if the item exists in the database (true if it exists, false if it doesn't).
-//   - error: An error, if any, occurred during the database query.
-func isItemInDatabase(db *sql.DB, guid string) (bool, error) {
-	query := "SELECT 1 FROM rss_items WHERE guid = ?"
-	var exists bool
-	err := db.QueryRow(query, guid).Scan(&exists)
-	if errors.Is(err, sql.ErrNoRows) {
-		return false, nil
-	} else if err != nil {
-		return false, err
-	}
-	return true, nil
+//   - *fetchResult: The fetched feed.
+//   - error: An error, if any, occurred while fetching the feed.
+func fetchFeedSource(ctx context.Context, source feedSource) (*fetchResult, error) {
+	if strings.ToLower(source.FeedType) == "scrap" {
+		return scrapeFeed(ctx, source.Url, source.ScrapConfig)
+	}
+	return fetchRSSFeed(ctx, source)
 }
 
-// insertRSSItem inserts a new RSS item into the database.
+// insertBatchSize is the maximum number of items inserted by a single INSERT statement.
+const insertBatchSize = 100
+
+// The maximum lengths, in bytes, of the item fields stored in rss_items, so that one oversized item cannot make the
+// insertion of the whole feed fail.
+const (
+	maxItemTitleLength  = 255   // rss_items.title, a VARCHAR(255).
+	maxItemAuthorLength = 767   // rss_items.author, a VARCHAR(767).
+	maxItemGuidLength   = 767   // rss_items.guid, a VARCHAR(767).
+	maxItemTextLength   = 65535 // rss_items.link and rss_items.summary_raw, TEXT columns.
+)
+
+// truncateText cuts a string to at most maxLength bytes, without splitting a UTF-8 character.
+func truncateText(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+	return strings.ToValidUTF8(value[:maxLength], "")
+}
+
+// sqlExecutor is implemented by both *sql.DB and *sql.Tx, so that writes can run inside or outside a transaction.
+type sqlExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertRSSItems inserts new RSS items into the database with multi-row INSERT statements.
+// Items whose identity key already exists in the feed are ignored.
 //
 // Parameters:
-//   - db: The database connection instance.
+//   - exec: The database connection instance or transaction.
 //   - feedId: The ID of the associated feed.
-//   - item: The *gofeed.Item representing the RSS item to insert.
+//   - items: The *gofeed.Item values representing the RSS items to insert.
 //
 // Returns:
 //   - error: An error, if any, occurred during the insertion process.
-func insertRSSItem(db *sql.DB, feedId int, item *gofeed.Item) error {
+func insertRSSItems(exec sqlExecutor, feedId int, items []*gofeed.Item) error {
+	for start := 0; start < len(items); start += insertBatchSize {
+		batch := items[start:min(start+insertBatchSize, len(items))]
+
+		placeholders := make([]string, 0, len(batch))
+		args := make([]any, 0, len(batch)*12)
+		for _, item := range batch {
+			// Handle nil values and concatenate authors' names
+			author := ""
+			if len(item.Authors) > 0 {
+				authorNames := make([]string, len(item.Authors))
+				for i, author := range item.Authors {
+					authorNames[i] = author.Name
+				}
+				author = strings.Join(authorNames, ", ")
+			}
+
+			placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, NOW())")
+			args = append(args,
+				feedId,
+				truncateText(item.Title, maxItemTitleLength),
+				truncateText(item.Link, maxItemTextLength),
+				truncateText(author, maxItemAuthorLength),
+				truncateText(item.Description, maxItemTextLength),
+				itemRawContent(item),
+				itemContentHash(item),
+				categoriesJSON(itemCategories(item)),
+				trimSpaces(truncateText(item.GUID, maxItemGuidLength)),
+				itemIdentityKey(item),
+				item.PublishedParsed,
+				item.UpdatedParsed,
+			)
+		}
+
+		query := `
+			INSERT INTO rss_items (rss_feed_id, title, link, author, summary_raw, content_raw, content_hash, categories, guid, item_key, published_date, updated_date, extraction_date)
+			VALUES ` + strings.Join(placeholders, ", ") + `
+			ON DUPLICATE KEY UPDATE id = id
+		`
+		if _, err := exec.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateFeedLastUpdate updates the last_update timestamp, the HTTP caching headers and the polling schedule
+// for the given feed in the database, and marks the fetch as successful.
+//
+// Parameters:
+//   - exec: The database connection instance or transaction.
+//   - feedId: The ID of the feed to update.
+//   - statusCode: The HTTP status code of the response.
+//   - etag: The ETag header to send on the next fetch, or an empty string.
+//   - lastModified: The Last-Modified header to send on the next fetch, or an empty string.
+//   - interval: The polling interval computed for the feed.
+//   - nextFetchAt: The time at which the feed should be fetched next.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the feed's last update timestamp.
+func updateFeedLastUpdate(exec sqlExecutor, feedId int, statusCode int, etag string, lastModified string, interval time.Duration, nextFetchAt time.Time) error {
 	query := `
-		INSERT INTO rss_items (rss_feed_id, title, link, author, summary_raw, content_raw, guid, published_date, updated_date, extraction_date)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())
+		UPDATE rss_feeds
+		SET last_update = NOW(), etag = NULLIF(?, ''), last_modified = NULLIF(?, ''),
+			fetch_interval = ?, next_fetch_at = ?, consecutive_failures = 0,
+			last_success_at = NOW(), last_http_status = ?, last_error = NULL
+		WHERE id = ?
 	`
-
-	// Handle nil values and concatenate authors' names
-	title := item.Title
-	link := item.Link
-	author := ""
-	if len(item.Authors) > 0 {
-		authorNames := make([]string, len(item.Authors))
-		for i, author := range item.Authors {
-			authorNames[i] = author.Name
-		}
-		author = strings.Join(authorNames, ", ")
-	}
-	description := item.Description
-	content := ""
-	if item.Content == "" {
-		content = item.Description
-	} else {
-		content = item.Content
-	}
-	guid := item.GUID
-	publishedDate := item.PublishedParsed
-	updatedDate := item.UpdatedParsed
-
-	_, err := db.Exec(
-		query,
-		feedId,
-		title,
-		link,
-		author,
-		description,
-		content,
-		guid,
-		publishedDate,
-		updatedDate,
-	)
+	_, err := exec.Exec(query, etag, lastModified, int(interval.Minutes()), nextFetchAt, statusCode, feedId)
 	return err
 }
 
-// updateFeedLastUpdate updates the last_update timestamp for the given feed in the database.
+// updateFeedFailure records a failed fetch for the given feed in the database and postpones its next fetch.
+// The feed is suspended once it has failed FEED_MAX_FAILURES times in a row.
 //
 // Parameters:
 //   - db: The database connection instance.
 //   - feedId: The ID of the feed to update.
+//   - failures: The number of consecutive failures, including this one.
+//   - fetchErr: The error that made the fetch fail.
+//   - nextFetchAt: The time at which the feed should be fetched next.
 //
 // Returns:
-//   - error: An error, if any, occurred while updating the feed's last update timestamp.
-func updateFeedLastUpdate(db *sql.DB, feedId int) error {
-	query := "UPDATE rss_feeds SET last_update = NOW() WHERE id = ?"
-	_, err := db.Exec(query, feedId)
+//   - error: An error, if any, occurred while updating the feed.
+func updateFeedFailure(db *sql.DB, feedId int, failures int, fetchErr error, nextFetchAt time.Time) error {
+	// Keep the HTTP status code when the server answered with an error.
+	var statusCode sql.NullInt64
+	var httpErr gofeed.HTTPError
+	if errors.As(fetchErr, &httpErr) {
+		statusCode = sql.NullInt64{Int64: int64(httpErr.StatusCode), Valid: true}
+	}
+
+	status := feedStatusActive
+	if failures >= getenvInt("FEED_MAX_FAILURES", 10) {
+		status = feedStatusSuspended
+		slog.Warn("Suspending RSS feed after too many consecutive failures", "Feed ID", feedId, "Failures", failures)
+	}
+
+	query := `
+		UPDATE rss_feeds
+		SET consecutive_failures = ?, next_fetch_at = ?, last_http_status = ?, last_error = ?, status = ?
+		WHERE id = ?
+	`
+	_, err := db.Exec(query, failures, nextFetchAt, statusCode, fetchErr.Error(), status, feedId)
 	return err
 }
 
-// FetchAllRSSData retrieves and processes RSS feeds for all the database.
-// It queries the database for feeds that need updating, fetches their items, and inserts them into the database if they don't already exist.
-// It also updates the feed's last update timestamp after processing.
+// storeFeedOutcome writes the outcome of a feed fetch to the database.
+// It inserts the items that are not already in the database, refreshes the ones that were updated, updates the feed's last update timestamp and
+// schedules its next fetch, backing off exponentially when the fetch failed.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - outcome: The outcome of the fetch, as produced by a fetch worker.
+func storeFeedOutcome(db *sql.DB, outcome feedFetchOutcome) {
+	feedId := outcome.Source.Id
+	now := time.Now()
+	minInterval, maxInterval := fetchIntervalBounds()
+
+	if errors.Is(outcome.Err, context.Canceled) {
+		// The process is shutting down, the feed will be fetched again on the next start.
+		slog.Info("RSS feed fetch aborted", "Feed URL", outcome.Source.Url)
+		return
+	}
+
+	if errors.Is(outcome.Err, errFeedGone) {
+		slog.Warn("RSS feed is gone, retiring it", "Feed URL", outcome.Source.Url)
+		if err := retireFeed(db, feedId); err != nil {
+			slog.Error("Error retiring feed", "Feed ID", feedId, "Error", err)
+		}
+		return
+	}
+
+	var retryErr retryAfterError
+	if errors.As(outcome.Err, &retryErr) {
+		// The host asked to slow down, which is not a failure of the feed.
+		retryIn := min(max(retryErr.Until.Sub(now), minInterval), maxInterval)
+		nextFetchAt := now.Add(retryIn)
+		slog.Warn("RSS feed host asked to retry later", "Feed URL", outcome.Source.Url, "Retry at", nextFetchAt, "Error", outcome.Err)
+		if err := updateFeedFailure(db, feedId, outcome.Source.ConsecutiveFailures, outcome.Err, nextFetchAt); err != nil {
+			slog.Error("Error recording feed failure", "Feed ID", feedId, "Error", err)
+		}
+		return
+	}
+
+	if outcome.Err != nil {
+		failures := outcome.Source.ConsecutiveFailures + 1
+		backoff := failureBackoff(failures, minInterval, maxInterval)
+		slog.Error("Error fetching RSS feed", "Feed URL", outcome.Source.Url, "Failures", failures, "Retry in", backoff, "Error", outcome.Err)
+		if err := updateFeedFailure(db, feedId, failures, outcome.Err, now.Add(backoff)); err != nil {
+			slog.Error("Error recording feed failure", "Feed ID", feedId, "Error", err)
+		}
+		return
+	}
+
+	result := outcome.Result
+	merged, err := updateFeedRedirect(db, outcome.Source, result.PermanentUrl)
+	if err != nil {
+		slog.Error("Error updating feed redirect", "Feed ID", feedId, "Error", err)
+	}
+	if merged {
+		// The feed no longer exists, its items are fetched through the feed it was merged into.
+		return
+	}
+
+	if result.NotModified {
+		// Nothing was downloaded, keep polling the feed at its previous pace.
+		interval := min(max(outcome.Source.FetchInterval, minInterval), maxInterval)
+		slog.Info("RSS feed not modified since the last fetch", "Feed URL", outcome.Source.Url)
+		if err := updateFeedLastUpdate(db, feedId, result.StatusCode, result.ETag, result.LastModified, interval, now.Add(interval)); err != nil {
+			slog.Error("Error updating feed last_update", "Feed ID", feedId, "Error", err)
+		}
+		return
+	}
+
+	// Schedule the next fetch from the publication cadence and the hints of the feed.
+	// The updates of a feed subscribed through WebSub are pushed by its hub, it is only polled as a safety net.
+	hints := parseScheduleHints(result.Feed)
+	interval := computeFetchInterval(result.Feed, hints, now, minInterval, maxInterval)
+	if result.DefaultInterval > 0 {
+		interval = min(max(result.DefaultInterval, minInterval), maxInterval)
+	}
+	if outcome.Source.WebSubActive {
+		interval = maxInterval
+	}
+
+	if err := ingestFeedItems(db, outcome.Source, result, interval, nextFetchTime(now, interval, hints)); err != nil {
+		// Nothing was written, the feed is backed off like a failed fetch so that it is not fetched again at every cycle.
+		failures := outcome.Source.ConsecutiveFailures + 1
+		backoff := failureBackoff(failures, minInterval, maxInterval)
+		slog.Error("Error ingesting RSS feed", "Feed URL", outcome.Source.Url, "Failures", failures, "Retry in", backoff, "Error", err)
+		if err := updateFeedFailure(db, feedId, failures, fmt.Errorf("ingestion failed: %w", err), now.Add(backoff)); err != nil {
+			slog.Error("Error recording feed failure", "Feed ID", feedId, "Error", err)
+		}
+		return
+	}
+
+	// Record the WebSub hub of the feed, the subscription is requested by the next renewal.
+	if websubEnabled() && !strings.EqualFold(outcome.Source.FeedType, "scrap") {
+		if err := updateWebSubSubscription(db, outcome.Source, result); err != nil {
+			slog.Error("Error updating WebSub subscription", "Feed ID", feedId, "Error", err)
+		}
+	}
+}
+
+// ingestMu serializes the ingestion of the polled feeds and of the content pushed by the WebSub hubs.
+var ingestMu sync.Mutex
+
+// ingestFeedItems stores the items of a fetched feed and updates the feed in a single transaction,
+// so that a feed is either fully ingested or not at all.
+// The items already known for the feed are loaded in one query, the updated ones are refreshed
+// and the new ones are inserted with multi-row INSERT statements, along with their media files.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - source: The feed that was fetched.
+//   - result: The result of the fetch.
+//   - interval: The polling interval computed for the feed.
+//   - nextFetchAt: The time at which the feed should be fetched next.
 //
 // Returns:
-//   - error: An error if any occurs during the fetching, processing, or database operations.
-func FetchAllRSSData() error {
-	// Get the database connection instance.
-	db := databases.GetDB()
+//   - error: An error, if any, occurred while writing to the database, in which case nothing was written.
+func ingestFeedItems(db *sql.DB, source feedSource, result *fetchResult, interval time.Duration, nextFetchAt time.Time) error {
+	ingestMu.Lock()
+	defer ingestMu.Unlock()
+
+	stored, err := loadStoredItems(db, source.Id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	// Split the items between the new ones and the ones to refresh.
+	var newItems []*gofeed.Item
+	var updatedIds []int
+	media := make(map[int][]types.RssItemMedia)
+	seen := make(map[string]bool)
+	var tombstoneKeys []string
+	for _, item := range result.Feed.Items {
+		itemKey := itemIdentityKey(item)
+		if seen[itemKey] {
+			continue
+		}
+		seen[itemKey] = true
+
+		storedItem, ok := stored[itemKey]
+		if !ok {
+			newItems = append(newItems, item)
+			continue
+		}
+		if storedItem.Purged {
+			if storedItem.Id == 0 {
+				// A deleted item, its tombstone is kept as long as the feed publishes it.
+				tombstoneKeys = append(tombstoneKeys, itemKey)
+			}
+			continue
+		}
+		changed, err := refreshStoredItem(tx, storedItem, item)
+		if err != nil {
+			return err
+		}
+		if changed {
+			updatedIds = append(updatedIds, storedItem.Id)
+			media[storedItem.Id] = itemMedia(item)
+		}
+	}
+
+	if err := insertRSSItems(tx, source.Id, newItems); err != nil {
+		return err
+	}
+	if err := touchTombstones(tx, source.Id, tombstoneKeys); err != nil {
+		return err
+	}
+
+	// Store the media files of the new items, which requires their IDs.
+	var mediaKeys []string
+	for _, item := range newItems {
+		if len(itemMedia(item)) > 0 {
+			mediaKeys = append(mediaKeys, itemIdentityKey(item))
+		}
+	}
+	if len(mediaKeys) > 0 {
+		ids, err := loadItemIds(tx, source.Id, mediaKeys)
+		if err != nil {
+			return err
+		}
+		for _, item := range newItems {
+			if id, ok := ids[itemIdentityKey(item)]; ok {
+				media[id] = itemMedia(item)
+			}
+		}
+	}
+	if err := replaceItemMedia(tx, media); err != nil {
+		return err
+	}
+
+	// Refresh the title, description, website, image and icon of the feed.
+	if err := updateFeedMetadata(tx, source, result); err != nil {
+		return err
+	}
+
+	// Record the repairs the document needed, so that the publisher can be told.
+	if err := updateFeedRepairs(tx, source.Id, result.Repairs); err != nil {
+		return err
+	}
+	if len(result.Repairs) > 0 {
+		slog.Warn("RSS feed repaired before parsing", "Feed URL", source.Url, "Repairs", result.Repairs)
+	}
+
+	// Keep the categories advertised by the channel of the feed.
+	if err := updateFeedSourceCategories(tx, source.Id, result.Feed); err != nil {
+		return err
+	}
+
+	// Update the last_update timestamp for the feed.
+	if err := updateFeedLastUpdate(tx, source.Id, result.StatusCode, result.ETag, result.LastModified, interval, nextFetchAt); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
-	// Define the SQL query to find feeds that need updating.
+	if len(newItems) > 0 || len(updatedIds) > 0 {
+		slog.Info("RSS feed ingested", "Feed URL", source.Url, "New items", len(newItems), "Updated items", updatedIds)
+	}
+	return nil
+}
+
+// queryFeedSources loads the feeds matching the given condition, with everything needed to fetch them.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - condition: The WHERE condition of the query on the rss_feeds table.
+//   - args: The arguments of the condition.
+//
+// Returns:
+//   - []feedSource: The matching feeds.
+//   - error: An error, if any, occurred while querying the database.
+func queryFeedSources(db *sql.DB, condition string, args ...any) ([]feedSource, error) {
 	query := `
-		SELECT id, url
+		SELECT id, user_id, url, type, scrap_config, fetch_full_text, etag, last_modified, fetch_interval, next_fetch_at, consecutive_failures, redirect_url, redirect_count, site_link, icon_checked_at,
+			auth_username, auth_password, request_headers,
+			EXISTS (
+				SELECT 1 FROM websub_subscriptions
+				WHERE websub_subscriptions.rss_feed_id = rss_feeds.id AND websub_subscriptions.state = 'active' AND websub_subscriptions.lease_expires_at > NOW()
+			)
 		FROM rss_feeds
-		WHERE last_update IS NULL OR last_update < NOW() - INTERVAL 15 MINUTE
-	`
+		WHERE ` + condition
 
 	// Execute the query to get the feeds.
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		slog.Error("Error querying rss_feeds", "Error", err)
-		return err
+		return nil, err
 	}
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
@@ -146,51 +533,80 @@ func FetchAllRSSData() error {
 		}
 	}(rows)
 
-	// Check if there are any rows returned
-	if !rows.Next() {
-		// No rows returned
-		slog.Info("No RSS feeds to fetch: No rows returned from the query")
-		return nil
-	}
-
-	// Iterate over the feeds.
+	var sources []feedSource
 	for rows.Next() {
-		var feedId int
-		var feedUrl string
-		if err := rows.Scan(&feedId, &feedUrl); err != nil {
+		var source feedSource
+		var feedETag sql.NullString
+		var feedLastModified sql.NullString
+		var feedInterval sql.NullInt64
+		var feedNextFetchAt sql.NullTime
+		var feedRedirectUrl sql.NullString
+		var feedScrapConfig sql.NullString
+		var feedSite sql.NullString
+		var feedIconCheckedAt sql.NullTime
+		var feedAuthUsername sql.NullString
+		var feedAuthPassword sql.NullString
+		var feedHeaders sql.NullString
+		if err := rows.Scan(&source.Id, &source.UserId, &source.Url, &source.FeedType, &feedScrapConfig, &source.FetchFullText, &feedETag, &feedLastModified, &feedInterval, &feedNextFetchAt, &source.ConsecutiveFailures, &feedRedirectUrl, &source.RedirectCount, &feedSite, &feedIconCheckedAt,
+			&feedAuthUsername, &feedAuthPassword, &feedHeaders, &source.WebSubActive); err != nil {
 			slog.Error("Error scanning rss_feeds row", "Error", err)
-			return err
+			return nil, err
 		}
-
-		// Fetch the RSS feed.
-		feed, err := fetchRSSFeed(feedUrl)
-		if err != nil {
-			slog.Error("Error fetching RSS feed", "Feed URL", feedUrl, "Error", err)
-			continue
+		source.ETag = feedETag.String
+		source.LastModified = feedLastModified.String
+		source.FetchInterval = time.Duration(feedInterval.Int64) * time.Minute
+		source.NextFetchAt = feedNextFetchAt.Time
+		source.RedirectUrl = feedRedirectUrl.String
+		source.ScrapConfig = feedScrapConfig.String
+		source.SiteLink = feedSite.String
+		source.IconCheckedAt = feedIconCheckedAt.Time
+		source.Credentials = feedCredentials{Username: feedAuthUsername.String, Password: feedAuthPassword.String}
+		if source.Credentials.Headers, err = parseFeedHeaders(feedHeaders.String); err != nil {
+			slog.Warn("Ignoring the custom headers of the feed", "Feed ID", source.Id, "Error", err)
 		}
+		sources = append(sources, source)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over rss_feeds rows", "Error", err)
+		return nil, err
+	}
+	return sources, nil
+}
 
-		// Process the RSS items.
-		for _, item := range feed.Items {
-			// Check if the item is already in the database.
-			if exists, err := isItemInDatabase(db, item.GUID); err != nil {
-				slog.Error("Error checking if item exists in database", "GUID", item.GUID, "Error", err)
-				continue
-			} else if exists {
-				continue
-			}
+// FetchAllRSSData retrieves and processes RSS feeds for all the database.
+// It queries the database for active feeds whose next fetch is due and fetches them in parallel with a pool of
+// FETCH_CONCURRENCY workers, never fetching more than FETCH_HOST_CONCURRENCY feeds of the same host at once.
+// The fetched items are then inserted into the database by a single writer if they don't already exist,
+// and the feed's metadata and last update timestamp are updated after processing.
+// When the context is cancelled, the pending requests are aborted and the feeds not fetched yet are left for the next run.
+//
+// Parameters:
+//   - ctx: The context of the run, cancelled when the process shuts down.
+//
+// Returns:
+//   - error: An error if any occurs during the fetching, processing, or database operations.
+func FetchAllRSSData(ctx context.Context) error {
+	// Get the database connection instance.
+	db := databases.GetDB()
 
-			// Insert the new item into the database.
-			if err := insertRSSItem(db, feedId, item); err != nil {
-				slog.Error("Error inserting RSS item", "GUID", item.GUID, "Error", err)
-				continue
-			}
-		}
+	// Find the feeds that need updating.
+	sources, err := queryFeedSources(db, "status = 'active' AND (next_fetch_at IS NULL OR next_fetch_at <= NOW())")
+	if err != nil {
+		return err
+	}
 
-		// Update the last_update timestamp for the feed.
-		if err := updateFeedLastUpdate(db, feedId); err != nil {
-			slog.Error("Error updating feed last_update", "Feed ID", feedId, "Error", err)
-			continue
-		}
+	// Check if there are any rows returned
+	if len(sources) == 0 {
+		// No rows returned
+		slog.Info("No RSS feeds to fetch: No rows returned from the query")
+		return nil
+	}
+
+	// Fetch the feeds in parallel and write the outcomes from this goroutine only.
+	concurrency := getenvInt("FETCH_CONCURRENCY", 4)
+	perHost := getenvInt("FETCH_HOST_CONCURRENCY", 1)
+	for outcome := range fetchFeedsConcurrently(ctx, sources, concurrency, perHost) {
+		storeFeedOutcome(db, outcome)
 	}
 
 	return nil
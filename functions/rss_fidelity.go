@@ -0,0 +1,251 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"github.com/cl3mcg/speakrine/databases"
+	"github.com/cl3mcg/speakrine/types"
+	gowebly "github.com/gowebly/helpers"
+	"golang.org/x/net/html"
+)
+
+// The actions taken when the output of the model is still unfaithful after FIDELITY_RETRIES, set by FIDELITY_FALLBACK.
+const (
+	fidelityFallbackReview        = "review"        // The output of the model is stored, flagged as needing a review.
+	fidelityFallbackDeterministic = "deterministic" // The output of cleanHTMLContent is stored instead, flagged as needing a review.
+)
+
+// The size of the word sequences compared to detect dropped paragraphs and truncation.
+const fidelityShingleSize = 3
+
+// fidelityBlockElements are the elements starting a new paragraph when the text of an article is extracted.
+var fidelityBlockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "blockquote": true, "pre": true, "td": true, "th": true, "dd": true, "dt": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "section": true, "article": true, "br": true,
+}
+
+// fidelitySettings holds the thresholds beyond which the output of the model is considered unfaithful to the article.
+type fidelitySettings struct {
+	Enabled              bool    // Whether the outputs of the model are verified.
+	MinRecall            float64 // The minimum share of the words of the article kept by the model.
+	MinPrecision         float64 // The minimum share of the words of the output found in the article, below which the model added content.
+	MaxDroppedParagraphs int     // The number of paragraphs of the article the model may drop, such as a disclaimer.
+	Retries              int     // The number of times the model is asked again for an unfaithful output.
+	Fallback             string  // The action taken when the output is still unfaithful, review or deterministic.
+}
+
+// loadFidelitySettings reads the fidelity thresholds from the FIDELITY_CHECK, FIDELITY_MIN_RECALL, FIDELITY_MIN_PRECISION,
+// FIDELITY_MAX_DROPPED_PARAGRAPHS, FIDELITY_RETRIES and FIDELITY_FALLBACK environment variables.
+func loadFidelitySettings() fidelitySettings {
+	settings := fidelitySettings{
+		Enabled:              getenvBool("FIDELITY_CHECK", true),
+		MinRecall:            float64(min(getenvInt("FIDELITY_MIN_RECALL", 80), 100)) / 100,
+		MinPrecision:         float64(min(getenvInt("FIDELITY_MIN_PRECISION", 90), 100)) / 100,
+		MaxDroppedParagraphs: getenvNonNegativeInt("FIDELITY_MAX_DROPPED_PARAGRAPHS", 1),
+		Retries:              getenvNonNegativeInt("FIDELITY_RETRIES", 1),
+		Fallback:             strings.ToLower(strings.TrimSpace(gowebly.Getenv("FIDELITY_FALLBACK", fidelityFallbackReview))),
+	}
+	if settings.Fallback != fidelityFallbackReview && settings.Fallback != fidelityFallbackDeterministic {
+		slog.Warn("Invalid environment variable, using the default value", "Variable", "FIDELITY_FALLBACK", "Value", settings.Fallback, "Default", fidelityFallbackReview)
+		settings.Fallback = fidelityFallbackReview
+	}
+	return settings
+}
+
+// fidelityReport holds the comparison of the text of an article with the text of the output of the model.
+type fidelityReport struct {
+	Recall            float64 // The share of the words of the article found in the output.
+	Precision         float64 // The share of the words of the output found in the article.
+	DroppedParagraphs int     // The number of paragraphs of the article missing from the output.
+	Truncated         bool    // Whether the end of the article is missing from the output.
+}
+
+// htmlParagraphs extracts the plain text of an HTML fragment, split into paragraphs at the block elements.
+func htmlParagraphs(content string) []string {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return []string{content}
+	}
+
+	var paragraphs []string
+	var current strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(current.String()); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+		current.Reset()
+	}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			current.WriteString(n.Data)
+			current.WriteByte(' ')
+		}
+		if n.Type == html.ElementNode && fidelityBlockElements[n.Data] {
+			flush()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && fidelityBlockElements[n.Data] {
+			flush()
+		}
+	}
+	walk(doc)
+	flush()
+	return paragraphs
+}
+
+// fidelityTokens splits a text into lower-cased words, ignoring punctuation.
+func fidelityTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// shingles returns the sequences of fidelityShingleSize consecutive words of a text.
+func shingles(tokens []string) []string {
+	var result []string
+	for i := 0; i+fidelityShingleSize <= len(tokens); i++ {
+		result = append(result, strings.Join(tokens[i:i+fidelityShingleSize], " "))
+	}
+	return result
+}
+
+// shingleCoverage returns the share of the given shingles found in the set, 1 if there are none.
+func shingleCoverage(list []string, set map[string]bool) float64 {
+	if len(list) == 0 {
+		return 1
+	}
+	found := 0
+	for _, shingle := range list {
+		if set[shingle] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(list))
+}
+
+// verifyFidelity compares the article given to the model with its output, word by word. Words added by the model
+// lower the precision, words dropped lower the recall. A paragraph of the article whose word sequences are mostly
+// missing from the output is dropped, and the output is truncated when the end of the article is mostly missing.
+//
+// Parameters:
+//   - source: The article given to the model, as cleaned by cleanHTMLContent.
+//   - output: The output of the model, as cleaned by cleanHTMLContent.
+//
+// Returns:
+//   - fidelityReport: The comparison of the article and the output.
+func verifyFidelity(source string, output string) fidelityReport {
+	outputTokens := fidelityTokens(strings.Join(htmlParagraphs(output), " "))
+	outputCounts := make(map[string]int)
+	for _, token := range outputTokens {
+		outputCounts[token]++
+	}
+	outputShingles := make(map[string]bool)
+	for _, shingle := range shingles(outputTokens) {
+		outputShingles[shingle] = true
+	}
+
+	report := fidelityReport{Recall: 1, Precision: 1}
+	var sourceTokens []string
+	for _, paragraph := range htmlParagraphs(source) {
+		tokens := fidelityTokens(paragraph)
+		// Short paragraphs, such as a caption or a byline, are too short to tell whether they were dropped.
+		if len(tokens) >= 8 && shingleCoverage(shingles(tokens), outputShingles) < 0.5 {
+			report.DroppedParagraphs++
+		}
+		sourceTokens = append(sourceTokens, tokens...)
+	}
+
+	common := 0
+	sourceCounts := make(map[string]int)
+	for _, token := range sourceTokens {
+		sourceCounts[token]++
+	}
+	for token, count := range sourceCounts {
+		common += min(count, outputCounts[token])
+	}
+	if len(sourceTokens) > 0 {
+		report.Recall = float64(common) / float64(len(sourceTokens))
+	}
+	if len(outputTokens) > 0 {
+		report.Precision = float64(common) / float64(len(outputTokens))
+	}
+
+	// The end of the article is its last tenth, at least 20 words.
+	if len(sourceTokens) >= 50 {
+		tail := sourceTokens[len(sourceTokens)-max(20, len(sourceTokens)/10):]
+		report.Truncated = shingleCoverage(shingles(tail), outputShingles) < 0.5
+	}
+	return report
+}
+
+// issues describes why the output of the model is unfaithful to the article, empty if it is faithful.
+func (r fidelityReport) issues(settings fidelitySettings) []string {
+	var issues []string
+	if r.Recall < settings.MinRecall {
+		issues = append(issues, fmt.Sprintf("kept %.0f%% of the words of the article", r.Recall*100))
+	}
+	if r.Precision < settings.MinPrecision {
+		issues = append(issues, fmt.Sprintf("added %.0f%% of words not in the article", (1-r.Precision)*100))
+	}
+	if r.DroppedParagraphs > settings.MaxDroppedParagraphs {
+		issues = append(issues, fmt.Sprintf("dropped %d paragraphs", r.DroppedParagraphs))
+	}
+	if r.Truncated {
+		issues = append(issues, "truncated the end of the article")
+	}
+	return issues
+}
+
+// ListItemsNeedingReview retrieves the most recently cleaned items whose formatted content may not be faithful to their
+// raw content, with the issues found by the fidelity verification.
+//
+// Parameters:
+//   - limit: The maximum number of items to return.
+//
+// Returns:
+//   - []types.RssItem: The items needing a review, with their issues in FidelityIssue.
+//   - error: An error, if any, occurred while querying the database.
+func ListItemsNeedingReview(limit int) ([]types.RssItem, error) {
+	db := databases.GetDB()
+
+	query := `
+		SELECT id, rss_feed_id, title, link, COALESCE(fidelity_issue, '')
+		FROM rss_items
+		WHERE needs_review = TRUE AND archived_at IS NULL
+		ORDER BY clean_finished_at DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		slog.Error("Error querying rss_items needing a review", "Error", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	var items []types.RssItem
+	for rows.Next() {
+		item := types.RssItem{NeedsReview: true}
+		if err := rows.Scan(&item.Id, &item.RssFeedId, &item.Title, &item.Link, &item.FidelityIssue); err != nil {
+			slog.Error("Error scanning rss_items row", "Error", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over rss_items rows", "Error", err)
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,54 @@
+package functions
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// fetchFullTextContent downloads the full article of the new and updated items of a feed from their link, for feeds
+// that only publish a teaser. The article is kept in the Custom map of the item under fullTextCustomKey, so that the
+// content published by the feed is still used to detect updates. An item keeps its teaser when its article cannot
+// be downloaded or extracted.
+//
+// Parameters:
+//   - ctx: The context of the fetch, cancelling it stops the downloads.
+//   - db: The database connection instance.
+//   - feedId: The ID of the feed.
+//   - feed: The fetched feed whose items are updated in place.
+func fetchFullTextContent(ctx context.Context, db *sql.DB, feedId int, feed *gofeed.Feed) {
+	known, err := loadStoredItems(db, feedId)
+	if err != nil {
+		slog.Error("Error loading the known items of the feed", "Feed ID", feedId, "Error", err)
+		return
+	}
+
+	for _, item := range feed.Items {
+		if ctx.Err() != nil {
+			return
+		}
+		if item.Link == "" {
+			continue
+		}
+		if stored, ok := known[itemIdentityKey(item)]; ok && (stored.Purged || !isContentChanged(stored, item)) {
+			continue
+		}
+
+		page, _, err := fetchPage(ctx, item.Link)
+		if err != nil {
+			slog.Warn("Error downloading full article, keeping the teaser", "Link", item.Link, "Error", err)
+			continue
+		}
+		content, err := extractArticleContent(page)
+		if err != nil {
+			slog.Warn("Error extracting full article, keeping the teaser", "Link", item.Link, "Error", err)
+			continue
+		}
+		if item.Custom == nil {
+			item.Custom = make(map[string]string)
+		}
+		item.Custom[fullTextCustomKey] = content
+	}
+}
@@ -0,0 +1,96 @@
+package functions
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"github.com/cl3mcg/speakrine/databases"
+	"github.com/cl3mcg/speakrine/types"
+)
+
+// The statuses a feed can have in the rss_feeds.status column.
+const (
+	feedStatusActive    = "active"    // The feed is fetched according to its schedule.
+	feedStatusSuspended = "suspended" // The feed failed too many times in a row and is no longer fetched.
+	feedStatusRetired   = "retired"   // The feed is gone (410) and is no longer fetched.
+)
+
+// ListUnhealthyFeeds retrieves the feeds that are suspended or whose last fetch failed, so they can be fixed or removed.
+// The feeds are ordered by status, then by decreasing number of consecutive failures.
+//
+// Returns:
+//   - []types.RssFeed: The unhealthy feeds, with their fetch state.
+//   - error: An error, if any, occurred while querying the database.
+func ListUnhealthyFeeds() ([]types.RssFeed, error) {
+	db := databases.GetDB()
+
+	query := `
+		SELECT id, common_name, url, status, consecutive_failures, last_http_status, last_error, last_success_at
+		FROM rss_feeds
+		WHERE status <> 'active' OR consecutive_failures > 0
+		ORDER BY status DESC, consecutive_failures DESC, id
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		slog.Error("Error querying unhealthy rss_feeds", "Error", err)
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	var feeds []types.RssFeed
+	for rows.Next() {
+		var feed types.RssFeed
+		var lastHttpStatus sql.NullInt64
+		var lastError sql.NullString
+		var lastSuccess sql.NullTime
+		if err := rows.Scan(&feed.Id, &feed.CommonName, &feed.Url, &feed.Status, &feed.FailureCount, &lastHttpStatus, &lastError, &lastSuccess); err != nil {
+			slog.Error("Error scanning rss_feeds row", "Error", err)
+			return nil, err
+		}
+		feed.LastHttpStatus = int(lastHttpStatus.Int64)
+		feed.LastError = lastError.String
+		feed.LastSuccess = lastSuccess.Time
+		feeds = append(feeds, feed)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over rss_feeds rows", "Error", err)
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+// ResumeFeed reactivates a suspended feed and resets its fetch state, so that it is fetched on the next cycle.
+//
+// Parameters:
+//   - feedId: The ID of the feed to resume.
+//
+// Returns:
+//   - bool: Whether a feed with the given ID exists.
+//   - error: An error, if any, occurred while updating the database.
+func ResumeFeed(feedId int) (bool, error) {
+	db := databases.GetDB()
+
+	query := `
+		UPDATE rss_feeds
+		SET status = 'active', consecutive_failures = 0, next_fetch_at = NULL
+		WHERE id = ?
+	`
+	res, err := db.Exec(query, feedId)
+	if err != nil {
+		slog.Error("Error resuming rss_feeds row", "Feed ID", feedId, "Error", err)
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
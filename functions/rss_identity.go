@@ -0,0 +1,47 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// itemIdentityKey computes the key identifying an item within its feed, stored in rss_items.item_key.
+// The key is the SHA-256 of the GUID of the item, or of its link when it has no GUID, or of its title and
+// publication date when it has neither. It must stay in line with the expression used by the
+// 008_rss_items_item_key.sql migration to compute the keys of existing items: the values are hashed as they are stored,
+// truncated to their column, and only spaces are trimmed, like the TRIM function of MySQL.
+//
+// Parameters:
+//   - item: The feed item.
+//
+// Returns:
+//   - string: The hexadecimal identity key of the item.
+func itemIdentityKey(item *gofeed.Item) string {
+	guid := trimSpaces(truncateText(item.GUID, maxItemGuidLength))
+	link := trimSpaces(truncateText(item.Link, maxItemTextLength))
+
+	var source string
+	switch {
+	case guid != "":
+		source = "guid:" + guid
+	case link != "":
+		source = "link:" + link
+	default:
+		published := ""
+		if item.PublishedParsed != nil {
+			published = item.PublishedParsed.UTC().Format("2006-01-02T15:04:05Z")
+		}
+		source = "title:" + truncateText(item.Title, maxItemTitleLength) + "|" + published
+	}
+
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// trimSpaces removes the leading and trailing spaces of a value, leaving the other blanks like the TRIM function of MySQL.
+func trimSpaces(value string) string {
+	return strings.Trim(value, " ")
+}
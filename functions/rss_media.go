@@ -0,0 +1,252 @@
+package functions
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/cl3mcg/speakrine/types"
+	"github.com/mmcdole/gofeed"
+)
+
+// mediaMedium returns the kind of a media (audio, video, image, other) from its MIME type or its media:content medium attribute.
+func mediaMedium(mimeType string, medium string) string {
+	medium = strings.ToLower(strings.TrimSpace(medium))
+	if medium == "audio" || medium == "video" || medium == "image" {
+		return medium
+	}
+
+	switch prefix, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(mimeType)), "/"); prefix {
+	case "audio", "video", "image":
+		return prefix
+	}
+	return "other"
+}
+
+// parseMediaDuration parses a duration expressed in seconds or as [[HH:]MM:]SS, as used by itunes:duration.
+// It returns 0 if the duration cannot be parsed.
+func parseMediaDuration(value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	duration := 0
+	for _, part := range strings.Split(value, ":") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || number < 0 {
+			return 0
+		}
+		duration = duration*60 + int(number)
+	}
+	return duration
+}
+
+// parsePositiveInt parses a positive integer, returning 0 if the value cannot be parsed.
+func parsePositiveInt(value string) int64 {
+	number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || number < 0 {
+		return 0
+	}
+	return number
+}
+
+// itemMedia collects the media files attached to a feed item from its enclosures, its media:content elements
+// and its iTunes extension, which provides the duration, episode and season numbers and artwork of podcast episodes.
+//
+// Parameters:
+//   - item: The feed item.
+//
+// Returns:
+//   - []types.RssItemMedia: The media files of the item, deduplicated by URL.
+func itemMedia(item *gofeed.Item) []types.RssItemMedia {
+	var media []types.RssItemMedia
+	seen := make(map[string]bool)
+	add := func(url string, mimeType string, medium string, length int64, duration int) {
+		url = strings.TrimSpace(url)
+		if url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		media = append(media, types.RssItemMedia{
+			Url:      url,
+			MimeType: strings.TrimSpace(mimeType),
+			Medium:   mediaMedium(mimeType, medium),
+			Length:   length,
+			Duration: duration,
+		})
+	}
+
+	for _, enclosure := range item.Enclosures {
+		add(enclosure.URL, enclosure.Type, "", parsePositiveInt(enclosure.Length), 0)
+	}
+	for _, content := range item.Extensions["media"]["content"] {
+		add(content.Attrs["url"], content.Attrs["type"], content.Attrs["medium"], parsePositiveInt(content.Attrs["fileSize"]), int(parsePositiveInt(content.Attrs["duration"])))
+	}
+
+	// Complete the media with the details of the podcast episode.
+	artwork := ""
+	if item.Image != nil {
+		artwork = item.Image.URL
+	}
+	if itunes := item.ITunesExt; itunes != nil {
+		if itunes.Image != "" {
+			artwork = itunes.Image
+		}
+		for i := range media {
+			if media[i].Medium != "audio" && media[i].Medium != "video" {
+				continue
+			}
+			if media[i].Duration == 0 {
+				media[i].Duration = parseMediaDuration(itunes.Duration)
+			}
+			media[i].Episode = int(parsePositiveInt(itunes.Episode))
+			media[i].Season = int(parsePositiveInt(itunes.Season))
+		}
+	}
+	for i := range media {
+		if media[i].Medium == "audio" || media[i].Medium == "video" {
+			media[i].ArtworkUrl = artwork
+		}
+	}
+
+	return media
+}
+
+// replaceItemMedia replaces the media files of the given items in the database.
+//
+// Parameters:
+//   - tx: The transaction in which the feed is ingested.
+//   - media: The media files to store, by item ID.
+//
+// Returns:
+//   - error: An error, if any, occurred while writing to the database.
+func replaceItemMedia(tx *sql.Tx, media map[int][]types.RssItemMedia) error {
+	if len(media) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(media))
+	idArgs := make([]any, 0, len(media))
+	placeholders := make([]string, 0, len(media))
+	args := make([]any, 0, len(media)*9)
+	for itemId, itemMedia := range media {
+		ids = append(ids, "?")
+		idArgs = append(idArgs, itemId)
+		for _, m := range itemMedia {
+			placeholders = append(placeholders, "(?, ?, NULLIF(?, ''), ?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, ''))")
+			args = append(args, itemId, m.Url, m.MimeType, m.Medium, m.Length, m.Duration, m.Episode, m.Season, m.ArtworkUrl)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM rss_item_media WHERE rss_item_id IN ("+strings.Join(ids, ", ")+")", idArgs...); err != nil {
+		return err
+	}
+	if len(placeholders) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO rss_item_media (rss_item_id, url, mime_type, medium, length, duration, episode, season, artwork_url)
+		VALUES ` + strings.Join(placeholders, ", ")
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+// loadItemIds retrieves the IDs of the items of a feed with the given identity keys.
+//
+// Parameters:
+//   - tx: The transaction in which the feed is ingested.
+//   - feedId: The ID of the feed.
+//   - itemKeys: The identity keys of the items.
+//
+// Returns:
+//   - map[string]int: The IDs of the items, by identity key.
+//   - error: An error, if any, occurred during the database query.
+func loadItemIds(tx *sql.Tx, feedId int, itemKeys []string) (map[string]int, error) {
+	ids := make(map[string]int)
+	for start := 0; start < len(itemKeys); start += insertBatchSize {
+		batch := itemKeys[start:min(start+insertBatchSize, len(itemKeys))]
+		args := []any{feedId}
+		for _, itemKey := range batch {
+			args = append(args, itemKey)
+		}
+
+		query := "SELECT id, item_key FROM rss_items WHERE rss_feed_id = ? AND item_key IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var itemKey string
+			if err := rows.Scan(&id, &itemKey); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			ids[itemKey] = id
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// loadItemMedia fills the Media of the given items with the media files stored for them in rss_item_media.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - items: The items whose media files are loaded.
+//
+// Returns:
+//   - error: An error, if any, occurred during the database query.
+func loadItemMedia(db *sql.DB, items []types.RssItem) error {
+	indexes := make(map[int]int, len(items))
+	for i, item := range items {
+		indexes[item.Id] = i
+	}
+
+	for start := 0; start < len(items); start += insertBatchSize {
+		batch := items[start:min(start+insertBatchSize, len(items))]
+		args := make([]any, 0, len(batch))
+		for _, item := range batch {
+			args = append(args, item.Id)
+		}
+
+		query := `
+			SELECT id, rss_item_id, url, mime_type, medium, length, duration, episode, season, artwork_url
+			FROM rss_item_media
+			WHERE rss_item_id IN (?` + strings.Repeat(", ?", len(batch)-1) + `)
+			ORDER BY id
+		`
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var media types.RssItemMedia
+			var mimeType, artworkUrl sql.NullString
+			var length, duration, episode, season sql.NullInt64
+			if err := rows.Scan(&media.Id, &media.RssItemId, &media.Url, &mimeType, &media.Medium, &length, &duration, &episode, &season, &artworkUrl); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			media.MimeType = mimeType.String
+			media.Length = length.Int64
+			media.Duration = int(duration.Int64)
+			media.Episode = int(episode.Int64)
+			media.Season = int(season.Int64)
+			media.ArtworkUrl = artworkUrl.String
+			item := &items[indexes[media.RssItemId]]
+			item.Media = append(item.Media, media)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
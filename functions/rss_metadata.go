@@ -0,0 +1,167 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// iconRefreshInterval is the time after which the favicon of a site is looked up again.
+const iconRefreshInterval = 7 * 24 * time.Hour
+
+// detectedFeedTypes are the feed types reported by gofeed that can be stored in rss_feeds.type.
+var detectedFeedTypes = []string{"rss", "atom", "json"}
+
+// feedSiteLink returns the absolute URL of the website of a feed, resolved against the URL of the feed.
+// It falls back to the root of the host of the feed when the feed does not link to its website.
+func feedSiteLink(feedUrl string, feed *gofeed.Feed) string {
+	base, err := url.Parse(feedUrl)
+	if err != nil {
+		return strings.TrimSpace(feed.Link)
+	}
+	if link := strings.TrimSpace(feed.Link); link != "" {
+		if siteUrl, err := base.Parse(link); err == nil && (siteUrl.Scheme == "http" || siteUrl.Scheme == "https") {
+			return siteUrl.String()
+		}
+	}
+	return (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/"}).String()
+}
+
+// feedImage returns the URL of the image of a feed, from its <image> element or its iTunes artwork.
+func feedImage(feed *gofeed.Feed) string {
+	if feed.Image != nil && strings.TrimSpace(feed.Image.URL) != "" {
+		return strings.TrimSpace(feed.Image.URL)
+	}
+	if feed.ITunesExt != nil {
+		return strings.TrimSpace(feed.ITunesExt.Image)
+	}
+	return ""
+}
+
+// needsIconRefresh reports whether the favicon of the website of a feed should be looked up,
+// because it was never looked up, the website of the feed changed or the last lookup is too old.
+func needsIconRefresh(source feedSource, siteLink string) bool {
+	return source.IconCheckedAt.IsZero() || source.SiteLink != siteLink || time.Since(source.IconCheckedAt) > iconRefreshInterval
+}
+
+// findIconLinks parses an HTML page and returns the absolute URLs of the icons announced by its
+// <link rel="icon">, <link rel="shortcut icon"> and <link rel="apple-touch-icon"> elements, the regular icons first.
+func findIconLinks(page []byte, pageUrl *url.URL) []string {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil
+	}
+
+	var icons, touchIcons []string
+	var traverse func(n *html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "link" {
+			attrs := make(map[string]string)
+			for _, attr := range n.Attr {
+				attrs[strings.ToLower(attr.Key)] = strings.TrimSpace(attr.Val)
+			}
+			if iconUrl, err := pageUrl.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				rels := strings.Fields(strings.ToLower(attrs["rel"]))
+				if contains(rels, "icon") {
+					icons = append(icons, iconUrl.String())
+				} else if contains(rels, "apple-touch-icon") || contains(rels, "apple-touch-icon-precomposed") {
+					touchIcons = append(touchIcons, iconUrl.String())
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(doc)
+
+	return append(icons, touchIcons...)
+}
+
+// discoverFavicon finds the favicon of a website. The icons announced by its home page are preferred,
+// then /favicon.ico is tried, and only kept if the server actually serves it.
+//
+// Parameters:
+//   - ctx: The context of the lookup.
+//   - siteLink: The URL of the website.
+//
+// Returns:
+//   - string: The URL of the favicon, empty if none was found.
+func discoverFavicon(ctx context.Context, siteLink string) string {
+	siteUrl, err := url.Parse(siteLink)
+	if err != nil || siteUrl.Host == "" {
+		return ""
+	}
+
+	if page, finalUrl, err := fetchPage(ctx, siteLink); err == nil {
+		if pageUrl, err := url.Parse(finalUrl); err == nil {
+			if icons := findIconLinks(page, pageUrl); len(icons) > 0 {
+				return icons[0]
+			}
+		}
+	}
+
+	faviconUrl := (&url.URL{Scheme: siteUrl.Scheme, Host: siteUrl.Host, Path: "/favicon.ico"}).String()
+	if _, finalUrl, err := fetchPage(ctx, faviconUrl); err == nil {
+		return finalUrl
+	}
+	return ""
+}
+
+// refreshFeedIcon looks up the favicon of the website of a fetched feed when it is due, and records it in the fetch result.
+// It is called by the fetch workers, so that the network requests do not hold the database writer.
+//
+// Parameters:
+//   - ctx: The context of the fetch.
+//   - source: The feed that was fetched.
+//   - result: The result of the fetch.
+func refreshFeedIcon(ctx context.Context, source feedSource, result *fetchResult) {
+	siteLink := feedSiteLink(source.Url, result.Feed)
+	if !needsIconRefresh(source, siteLink) {
+		return
+	}
+	result.IconUrl = discoverFavicon(ctx, siteLink)
+	result.IconChecked = true
+}
+
+// updateFeedMetadata stores the metadata of a fetched feed: its title, description, website, image and detected type,
+// and its favicon when it was looked up. The common name chosen by the user is only filled when it is empty,
+// and the type of scraped feeds is kept.
+//
+// Parameters:
+//   - exec: The database connection instance or transaction.
+//   - source: The feed that was fetched.
+//   - result: The result of the fetch.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the feed.
+func updateFeedMetadata(exec sqlExecutor, source feedSource, result *fetchResult) error {
+	feed := result.Feed
+	title := truncateText(strings.Join(strings.Fields(feed.Title), " "), 255)
+
+	feedType := source.FeedType
+	if detected := strings.ToLower(feed.FeedType); source.FeedType != "scrap" && contains(detectedFeedTypes, detected) {
+		feedType = detected
+	}
+
+	query := `
+		UPDATE rss_feeds
+		SET title = NULLIF(?, ''), description = NULLIF(?, ''), site_link = NULLIF(?, ''), image_url = NULLIF(?, ''), type = ?,
+			common_name = IF(common_name = '', ?, common_name)
+		WHERE id = ?
+	`
+	if _, err := exec.Exec(query, title, strings.TrimSpace(feed.Description), feedSiteLink(source.Url, feed), feedImage(feed), feedType, title, source.Id); err != nil {
+		return err
+	}
+
+	if !result.IconChecked {
+		return nil
+	}
+	_, err := exec.Exec("UPDATE rss_feeds SET icon_url = NULLIF(?, ''), icon_checked_at = NOW() WHERE id = ?", result.IconUrl, source.Id)
+	return err
+}
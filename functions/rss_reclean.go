@@ -0,0 +1,105 @@
+package functions
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+
+	"github.com/cl3mcg/speakrine/databases"
+)
+
+// RecleanResult holds the outcome of a request to clean again the items cleaned with an older prompt.
+type RecleanResult struct {
+	PromptVersion string // The version of the prompt the items will be cleaned with.
+	Requeued      int64  // The number of items queued to be cleaned again.
+	RawDropped    int64  // The number of items that cannot be cleaned again, their raw content having been dropped.
+}
+
+// olderPromptVersions lists the prompt versions recorded with the cleaned items that are older than the given one.
+// The items cleaned before the versions were recorded are returned as an empty string.
+func olderPromptVersions(db *sql.DB, current int) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT COALESCE(clean_prompt_version, '') FROM rss_items WHERE clean_status = ?", cleanStatusCleaned)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	var versions []string
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, err
+		}
+		if version, ok := parsePromptVersion(label); !ok || version < current {
+			versions = append(versions, label)
+		}
+	}
+	return versions, rows.Err()
+}
+
+// RecleanItems queues the items cleaned with an older version of the cleaning prompt than the current one,
+// set by LLM_PROMPT_VERSION or the latest, so that they are cleaned again at the next cycle. Their current
+// formatted content is kept until then. Items whose raw content was dropped by RETENTION_DROP_RAW cannot be
+// cleaned again and are only counted.
+//
+// Parameters:
+//   - feedIds: The IDs of the feeds whose items are cleaned again, or nil for every feed.
+//
+// Returns:
+//   - RecleanResult: The version of the prompt and the number of items queued.
+//   - error: An error, if any, occurred while loading the prompt or updating the database.
+func RecleanItems(feedIds []int) (RecleanResult, error) {
+	db := databases.GetDB()
+
+	current, err := cleaningPrompt()
+	if err != nil {
+		slog.Error("Error loading the cleaning prompt", "Error", err)
+		return RecleanResult{}, err
+	}
+	result := RecleanResult{PromptVersion: current.VersionLabel()}
+
+	versions, err := olderPromptVersions(db, current.Version)
+	if err != nil {
+		slog.Error("Error querying the prompt versions of rss_items", "Error", err)
+		return result, err
+	}
+	if len(versions) == 0 {
+		return result, nil
+	}
+
+	condition := "clean_status = ? AND COALESCE(clean_prompt_version, '') IN (?" + strings.Repeat(", ?", len(versions)-1) + ")"
+	args := []any{cleanStatusCleaned}
+	for _, version := range versions {
+		args = append(args, version)
+	}
+	if len(feedIds) > 0 {
+		condition += " AND rss_feed_id IN (?" + strings.Repeat(", ?", len(feedIds)-1) + ")"
+		for _, feedId := range feedIds {
+			args = append(args, feedId)
+		}
+	}
+
+	query := `
+		UPDATE rss_items
+		SET clean_status = ?, clean_attempts = 0, clean_error = NULL
+		WHERE ` + condition + ` AND content_raw IS NOT NULL AND LENGTH(content_raw) > 10`
+	res, err := db.Exec(query, append([]any{cleanStatusPending}, args...)...)
+	if err != nil {
+		slog.Error("Error queuing rss_items to be cleaned again", "Error", err)
+		return result, err
+	}
+	if result.Requeued, err = res.RowsAffected(); err != nil {
+		return result, err
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM rss_items WHERE "+condition+" AND content_raw IS NULL", args...).Scan(&result.RawDropped); err != nil {
+		slog.Error("Error counting rss_items without raw content", "Error", err)
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package functions
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxFeedRedirects is the maximum number of redirects followed when fetching a feed.
+const maxFeedRedirects = 10
+
+// errFeedGone is returned when the server answers 410 Gone, meaning the feed has been removed for good.
+var errFeedGone = errors.New("the feed is gone (410)")
+
+// isRedirect reports whether the HTTP status code is a redirect that carries a Location header.
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// doFeedRequest sends the request built by newRequest and follows the redirects itself.
+// When every redirect of the chain is permanent (301 or 308), the final URL is returned as the permanent URL of the feed.
+// Every request of the chain goes through the host throttle.
+//
+// Parameters:
+//   - url: The URL to request.
+//   - newRequest: The function building the request for a given URL.
+//
+// Returns:
+//   - *http.Response: The response of the last request of the redirect chain.
+//   - string: The final URL if the chain only contains permanent redirects, or an empty string.
+//   - error: An error, if any, occurred while sending the requests.
+func doFeedRequest(url string, newRequest func(url string) (*http.Request, error)) (*http.Response, string, error) {
+	currentUrl := url
+	permanent := true
+	for hops := 0; ; hops++ {
+		req, err := newRequest(currentUrl)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp, err := politeDo(feedHTTPClient(), req)
+		if err != nil {
+			return nil, "", err
+		}
+		if !isRedirect(resp.StatusCode) {
+			permanentUrl := ""
+			if permanent && currentUrl != url {
+				permanentUrl = currentUrl
+			}
+			return resp, permanentUrl, nil
+		}
+
+		// Drain the body of the redirect so the connection can be reused.
+		location, err := resp.Location()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid redirect location from %v: %w", currentUrl, err)
+		}
+		if hops >= maxFeedRedirects {
+			return nil, "", fmt.Errorf("stopped after %d redirects", maxFeedRedirects)
+		}
+
+		if resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusPermanentRedirect {
+			permanent = false
+		}
+		currentUrl = location.String()
+	}
+}
+
+// updateFeedRedirect records the permanent redirect observed for the given feed.
+// Once the same target has been observed FEED_REDIRECT_THRESHOLD times in a row, the URL of the feed is rewritten.
+// If the user already has a feed with the target URL, the items of the redirected feed are merged into it
+// and the redirected feed is deleted.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - source: The feed that was redirected.
+//   - permanentUrl: The URL the feed is permanently redirected to, or an empty string if it was not.
+//
+// Returns:
+//   - bool: Whether the feed was merged into an existing feed and deleted.
+//   - error: An error, if any, occurred while updating the database.
+func updateFeedRedirect(db *sql.DB, source feedSource, permanentUrl string) (bool, error) {
+	if permanentUrl == "" {
+		if source.RedirectUrl == "" {
+			return false, nil
+		}
+		// The redirect is not stable, start over.
+		_, err := db.Exec("UPDATE rss_feeds SET redirect_url = NULL, redirect_count = 0 WHERE id = ?", source.Id)
+		return false, err
+	}
+
+	count := 1
+	if permanentUrl == source.RedirectUrl {
+		count = source.RedirectCount + 1
+	}
+	if count < getenvInt("FEED_REDIRECT_THRESHOLD", 3) {
+		_, err := db.Exec("UPDATE rss_feeds SET redirect_url = ?, redirect_count = ? WHERE id = ?", permanentUrl, count, source.Id)
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	// Look for a feed of the same user already subscribed to the target URL.
+	var targetId int
+	merged := false
+	err = tx.QueryRow("SELECT id FROM rss_feeds WHERE user_id = ? AND url = ? FOR UPDATE", source.UserId, permanentUrl).Scan(&targetId)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		query := "UPDATE rss_feeds SET url = ?, redirect_url = NULL, redirect_count = 0 WHERE id = ?"
+		if _, err := tx.Exec(query, permanentUrl, source.Id); err != nil {
+			return false, err
+		}
+		slog.Info("RSS feed moved permanently, updating its URL", "Feed ID", source.Id, "Old URL", source.Url, "New URL", permanentUrl)
+	case err != nil:
+		return false, err
+	default:
+		// Items the target feed already has are left behind and deleted along with the redirected feed.
+		if _, err := tx.Exec("UPDATE IGNORE rss_items SET rss_feed_id = ? WHERE rss_feed_id = ?", targetId, source.Id); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("DELETE FROM rss_feeds WHERE id = ?", source.Id); err != nil {
+			return false, err
+		}
+		merged = true
+		slog.Info("RSS feed moved permanently to an existing feed, merging them", "Feed ID", source.Id, "Target feed ID", targetId, "New URL", permanentUrl)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return merged, nil
+}
+
+// retireFeed marks the given feed as retired, so that it is no longer fetched.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - feedId: The ID of the feed to retire.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the database.
+func retireFeed(db *sql.DB, feedId int) error {
+	query := `
+		UPDATE rss_feeds
+		SET status = ?, last_http_status = ?, last_error = ?, next_fetch_at = NULL
+		WHERE id = ?
+	`
+	_, err := db.Exec(query, feedStatusRetired, http.StatusGone, errFeedGone.Error(), feedId)
+	return err
+}
@@ -0,0 +1,274 @@
+package functions
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+
+	"github.com/cl3mcg/speakrine/databases"
+	gowebly "github.com/gowebly/helpers"
+)
+
+// purgeBatchSize is the maximum number of items purged by a single statement.
+const purgeBatchSize = 500
+
+// tombstoneGraceDays is the number of days a tombstone is kept after its feed last published the deleted item.
+const tombstoneGraceDays = 30
+
+// The retention modes set by RETENTION_MODE.
+const (
+	retentionModeDelete  = "delete"  // Expired items are deleted, a tombstone keeps them from being ingested again.
+	retentionModeArchive = "archive" // Expired items are kept without their content and hidden from the lists.
+)
+
+// retentionMode returns the retention mode set by RETENTION_MODE, deleting expired items by default.
+func retentionMode() string {
+	mode := strings.ToLower(strings.TrimSpace(gowebly.Getenv("RETENTION_MODE", "")))
+	if mode != retentionModeArchive {
+		if mode != "" && mode != retentionModeDelete {
+			slog.Warn("Invalid environment variable, using the default value", "Variable", "RETENTION_MODE", "Value", mode, "Default", retentionModeDelete)
+		}
+		return retentionModeDelete
+	}
+	return mode
+}
+
+// expiredItemIds retrieves the IDs of the items that are past the retention of their feed: older than its
+// retention_days, or beyond its newest retention_max_items. The retention of a feed falls back to RETENTION_DAYS
+// and RETENTION_MAX_ITEMS, and 0 keeps its items forever. Starred and pinned items never expire.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - days: The global retention, in days, 0 to keep items forever.
+//   - maxItems: The global maximum number of items per feed, 0 for no maximum.
+//
+// Returns:
+//   - []int: The IDs of up to purgeBatchSize expired items.
+//   - error: An error, if any, occurred while querying the database.
+func expiredItemIds(db *sql.DB, days int, maxItems int) ([]int, error) {
+	query := `
+		SELECT id
+		FROM (
+			SELECT
+				rss_items.id,
+				rss_items.is_starred,
+				rss_items.is_pinned,
+				COALESCE(rss_items.published_date, rss_items.extraction_date) AS item_date,
+				COALESCE(rss_feeds.retention_days, ?) AS retention_days,
+				COALESCE(rss_feeds.retention_max_items, ?) AS retention_max_items,
+				ROW_NUMBER() OVER (
+					PARTITION BY rss_items.rss_feed_id
+					ORDER BY COALESCE(rss_items.published_date, rss_items.extraction_date) DESC, rss_items.id DESC
+				) AS position
+			FROM rss_items
+			JOIN rss_feeds ON rss_items.rss_feed_id = rss_feeds.id
+			WHERE rss_items.archived_at IS NULL
+		) AS ranked_items
+		WHERE is_starred = FALSE AND is_pinned = FALSE
+			AND (
+				(retention_days > 0 AND item_date < NOW() - INTERVAL retention_days DAY)
+				OR (retention_max_items > 0 AND position > retention_max_items)
+			)
+		LIMIT ?
+	`
+
+	rows, err := db.Query(query, days, maxItems, purgeBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// purgeItems deletes or archives the given items in a single transaction.
+// Deleted items leave a tombstone in rss_item_tombstones, so that they are not ingested again while their feed
+// still publishes them. Archived items lose their raw and formatted content and their revisions, but keep their
+// title, link and summary.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - ids: The IDs of the items to purge.
+//   - mode: The retention mode, retentionModeDelete or retentionModeArchive.
+//
+// Returns:
+//   - error: An error, if any, occurred while writing to the database, in which case nothing was purged.
+func purgeItems(db *sql.DB, ids []int, mode string) error {
+	placeholders := "?" + strings.Repeat(", ?", len(ids)-1)
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if mode == retentionModeArchive {
+		if _, err := tx.Exec("DELETE FROM rss_item_revisions WHERE rss_item_id IN ("+placeholders+")", args...); err != nil {
+			return err
+		}
+		query := `
+			UPDATE rss_items
+			SET archived_at = NOW(), content_raw = NULL, content_formatted = NULL
+			WHERE id IN (` + placeholders + `)`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	query := `
+		INSERT IGNORE INTO rss_item_tombstones (rss_feed_id, item_key, deleted_at)
+		SELECT rss_feed_id, item_key, NOW() FROM rss_items WHERE id IN (` + placeholders + `)`
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM rss_items WHERE id IN ("+placeholders+")", args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// touchTombstones records that the feed still publishes the given deleted items, so that their tombstones are kept.
+//
+// Parameters:
+//   - tx: The transaction in which the feed is ingested.
+//   - feedId: The ID of the feed.
+//   - itemKeys: The identity keys of the deleted items published by the feed.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the database.
+func touchTombstones(tx *sql.Tx, feedId int, itemKeys []string) error {
+	for start := 0; start < len(itemKeys); start += insertBatchSize {
+		batch := itemKeys[start:min(start+insertBatchSize, len(itemKeys))]
+		args := []any{feedId}
+		for _, itemKey := range batch {
+			args = append(args, itemKey)
+		}
+
+		query := "UPDATE rss_item_tombstones SET last_seen_at = NOW() WHERE rss_feed_id = ? AND item_key IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneTombstones deletes the tombstones of the items their feed has not published for tombstoneGraceDays days.
+// Such an item can no longer be ingested again, and the tombstones are read at every fetch of their feed.
+//
+// Parameters:
+//   - db: The database connection instance.
+//
+// Returns:
+//   - int64: The number of tombstones deleted.
+//   - error: An error, if any, occurred while updating the database.
+func pruneTombstones(db *sql.DB) (int64, error) {
+	res, err := db.Exec("DELETE FROM rss_item_tombstones WHERE last_seen_at < NOW() - INTERVAL ? DAY", tombstoneGraceDays)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// dropCleanedRawContent drops the raw content of the items that have a formatted content, to reclaim space.
+// The raw content is no longer needed once an item has been cleaned, unless it has to be cleaned again.
+//
+// Parameters:
+//   - db: The database connection instance.
+//
+// Returns:
+//   - int64: The number of items whose raw content was dropped.
+//   - error: An error, if any, occurred while updating the database.
+func dropCleanedRawContent(db *sql.DB) (int64, error) {
+	query := `
+		UPDATE rss_items
+		SET content_raw = NULL
+		WHERE content_raw IS NOT NULL AND clean_status = ?
+	`
+	res, err := db.Exec(query, cleanStatusCleaned)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeOldItems applies the retention policy of the feeds. The items older than RETENTION_DAYS, or beyond the newest
+// RETENTION_MAX_ITEMS of their feed, are deleted or archived according to RETENTION_MODE, the retention_days and
+// retention_max_items columns of rss_feeds overriding the global values. Starred and pinned items are always kept.
+// The tombstones of the deleted items their feed no longer publishes are pruned after tombstoneGraceDays days.
+// When RETENTION_DROP_RAW is true, the raw content of the cleaned items is dropped too.
+//
+// Parameters:
+//   - ctx: The context of the run, cancelled when the process shuts down.
+//
+// Returns:
+//   - error: An error, if any, occurred while purging the items.
+func PurgeOldItems(ctx context.Context) error {
+	db := databases.GetDB()
+	days := getenvNonNegativeInt("RETENTION_DAYS", 0)
+	maxItems := getenvNonNegativeInt("RETENTION_MAX_ITEMS", 0)
+	mode := retentionMode()
+
+	purged := 0
+	for ctx.Err() == nil {
+		ids, err := expiredItemIds(db, days, maxItems)
+		if err != nil {
+			slog.Error("Error querying expired rss_items", "Error", err)
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if err := purgeItems(db, ids, mode); err != nil {
+			slog.Error("Error purging expired rss_items", "Error", err)
+			return err
+		}
+		purged += len(ids)
+	}
+	if purged > 0 {
+		slog.Info("Expired RSS items purged", "Mode", mode, "Items", purged)
+	}
+
+	if ctx.Err() == nil {
+		count, err := pruneTombstones(db)
+		if err != nil {
+			slog.Error("Error pruning rss_item_tombstones", "Error", err)
+			return err
+		}
+		if count > 0 {
+			slog.Info("Tombstones of RSS items no longer published pruned", "Tombstones", count)
+		}
+	}
+
+	if getenvBool("RETENTION_DROP_RAW", false) && ctx.Err() == nil {
+		count, err := dropCleanedRawContent(db)
+		if err != nil {
+			slog.Error("Error dropping the raw content of cleaned rss_items", "Error", err)
+			return err
+		}
+		if count > 0 {
+			slog.Info("Raw content of cleaned RSS items dropped", "Items", count)
+		}
+	}
+	return nil
+}
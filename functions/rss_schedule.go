@@ -0,0 +1,216 @@
+package functions
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// maxCadenceSamples is the number of most recent items used to estimate the publication cadence of a feed.
+const maxCadenceSamples = 20
+
+// scheduleHints holds the polling hints advertised by a feed.
+type scheduleHints struct {
+	TTL          time.Duration         // Minimum caching time advertised by the RSS <ttl> element.
+	UpdatePeriod time.Duration         // Update period advertised by the sy:updatePeriod and sy:updateFrequency elements.
+	SkipHours    map[int]bool          // Hours (GMT) during which the feed should not be fetched, from <skipHours>.
+	SkipDays     map[time.Weekday]bool // Days during which the feed should not be fetched, from <skipDays>.
+}
+
+// scheduleRSSTranslator is a gofeed RSS translator that keeps the <ttl>, <skipHours> and <skipDays>
+// elements of the channel in the Custom map of the universal feed, as gofeed drops them otherwise.
+type scheduleRSSTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+// Translate converts an rss.Feed into the universal gofeed.Feed and keeps its polling hints.
+func (t *scheduleRSSTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	rssFeed, ok := feed.(*rss.Feed)
+	if !ok {
+		return result, nil
+	}
+	if result.Custom == nil {
+		result.Custom = make(map[string]string)
+	}
+	if rssFeed.TTL != "" {
+		result.Custom["ttl"] = rssFeed.TTL
+	}
+	if len(rssFeed.SkipHours) > 0 {
+		result.Custom["skipHours"] = strings.Join(rssFeed.SkipHours, ",")
+	}
+	if len(rssFeed.SkipDays) > 0 {
+		result.Custom["skipDays"] = strings.Join(rssFeed.SkipDays, ",")
+	}
+	return result, nil
+}
+
+// newFeedParser creates a gofeed parser that keeps the polling hints of RSS feeds.
+func newFeedParser() *gofeed.Parser {
+	fp := gofeed.NewParser()
+	fp.RSSTranslator = &scheduleRSSTranslator{}
+	fp.AtomTranslator = &hubAtomTranslator{}
+	return fp
+}
+
+// parseScheduleHints extracts the polling hints advertised by a feed.
+//
+// Parameters:
+//   - feed: The parsed feed, as returned by a parser created with newFeedParser.
+//
+// Returns:
+//   - scheduleHints: The polling hints of the feed, with zero values for the hints it does not advertise.
+func parseScheduleHints(feed *gofeed.Feed) scheduleHints {
+	hints := scheduleHints{SkipHours: make(map[int]bool), SkipDays: make(map[time.Weekday]bool)}
+
+	if ttl, err := strconv.Atoi(strings.TrimSpace(feed.Custom["ttl"])); err == nil && ttl > 0 {
+		hints.TTL = time.Duration(ttl) * time.Minute
+	}
+
+	for _, strHour := range strings.Split(feed.Custom["skipHours"], ",") {
+		if hour, err := strconv.Atoi(strings.TrimSpace(strHour)); err == nil && hour >= 0 && hour <= 24 {
+			// Some publishers use 24 for midnight.
+			hints.SkipHours[hour%24] = true
+		}
+	}
+
+	weekdays := map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+		"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	}
+	for _, strDay := range strings.Split(feed.Custom["skipDays"], ",") {
+		if day, ok := weekdays[strings.ToLower(strings.TrimSpace(strDay))]; ok {
+			hints.SkipDays[day] = true
+		}
+	}
+
+	if sy, ok := feed.Extensions["sy"]; ok {
+		periods := map[string]time.Duration{
+			"hourly":  time.Hour,
+			"daily":   24 * time.Hour,
+			"weekly":  7 * 24 * time.Hour,
+			"monthly": 30 * 24 * time.Hour,
+			"yearly":  365 * 24 * time.Hour,
+		}
+		var period time.Duration
+		if values := sy["updatePeriod"]; len(values) > 0 {
+			period = periods[strings.ToLower(strings.TrimSpace(values[0].Value))]
+		}
+		frequency := 1
+		if values := sy["updateFrequency"]; len(values) > 0 {
+			if value, err := strconv.Atoi(strings.TrimSpace(values[0].Value)); err == nil && value > 0 {
+				frequency = value
+			}
+		}
+		hints.UpdatePeriod = period / time.Duration(frequency)
+	}
+
+	return hints
+}
+
+// computeFetchInterval estimates how often a feed should be polled from its observed publication cadence.
+// The interval is the median gap between the publication dates of the most recent items, stretched when the
+// feed has been quiet for longer than that, raised to the feed's <ttl> and sy:updatePeriod hints, and kept
+// between minInterval and maxInterval.
+//
+// Parameters:
+//   - feed: The parsed feed.
+//   - hints: The polling hints of the feed.
+//   - now: The current time.
+//   - minInterval: The shortest allowed interval.
+//   - maxInterval: The longest allowed interval.
+//
+// Returns:
+//   - time.Duration: The interval to wait before fetching the feed again.
+func computeFetchInterval(feed *gofeed.Feed, hints scheduleHints, now time.Time, minInterval time.Duration, maxInterval time.Duration) time.Duration {
+	// Collect the publication dates of the items, falling back to their update dates.
+	var dates []time.Time
+	for _, item := range feed.Items {
+		switch {
+		case item.PublishedParsed != nil:
+			dates = append(dates, *item.PublishedParsed)
+		case item.UpdatedParsed != nil:
+			dates = append(dates, *item.UpdatedParsed)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+	if len(dates) > maxCadenceSamples {
+		dates = dates[:maxCadenceSamples]
+	}
+
+	interval := maxInterval
+	if len(dates) >= 2 {
+		gaps := make([]time.Duration, 0, len(dates)-1)
+		for i := 1; i < len(dates); i++ {
+			gaps = append(gaps, dates[i-1].Sub(dates[i]))
+		}
+		sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+		interval = gaps[len(gaps)/2]
+
+		// A feed that has been silent for longer than its usual cadence is polled less often.
+		if silence := now.Sub(dates[0]); silence > interval {
+			interval = (interval + silence) / 2
+		}
+	}
+
+	interval = max(interval, hints.TTL, hints.UpdatePeriod)
+	return min(max(interval, minInterval), maxInterval)
+}
+
+// failureBackoff computes the interval to wait before retrying a feed after consecutive failures.
+// The interval doubles with each failure, starting from minInterval and capped at maxInterval.
+//
+// Parameters:
+//   - failures: The number of consecutive failures, including the current one.
+//   - minInterval: The interval after the first failure.
+//   - maxInterval: The longest allowed interval.
+//
+// Returns:
+//   - time.Duration: The interval to wait before fetching the feed again.
+func failureBackoff(failures int, minInterval time.Duration, maxInterval time.Duration) time.Duration {
+	interval := minInterval
+	for i := 1; i < failures && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	return min(interval, maxInterval)
+}
+
+// nextFetchTime computes when a feed should be fetched next, moving the time forward out of the hours and
+// days the feed asks not to be fetched.
+//
+// Parameters:
+//   - now: The current time.
+//   - interval: The interval to wait before fetching the feed again.
+//   - hints: The polling hints of the feed.
+//
+// Returns:
+//   - time.Time: The time of the next fetch.
+func nextFetchTime(now time.Time, interval time.Duration, hints scheduleHints) time.Time {
+	next := now.Add(interval)
+
+	// skipHours and skipDays are expressed in GMT; give up after a week if every hour is skipped.
+	for i := 0; i < 7*24; i++ {
+		utc := next.UTC()
+		if !hints.SkipHours[utc.Hour()] && !hints.SkipDays[utc.Weekday()] {
+			break
+		}
+		next = utc.Truncate(time.Hour).Add(time.Hour)
+	}
+	return next
+}
+
+// fetchIntervalBounds returns the shortest and longest polling intervals allowed for a feed.
+// The shortest interval is FETCH_INTERVAL and the longest is FETCH_MAX_INTERVAL, both in minutes.
+func fetchIntervalBounds() (minInterval time.Duration, maxInterval time.Duration) {
+	minInterval = time.Duration(getenvInt("FETCH_INTERVAL", 15)) * time.Minute
+	maxInterval = time.Duration(getenvInt("FETCH_MAX_INTERVAL", 24*60)) * time.Minute
+	return minInterval, max(minInterval, maxInterval)
+}
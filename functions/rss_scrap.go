@@ -0,0 +1,197 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+)
+
+// scrapDefaultInterval is the polling interval, in minutes, of a scraped feed whose items carry no date to estimate
+// its publication cadence from.
+const scrapDefaultInterval = 60
+
+// scrapDateLayouts are the date layouts tried when a scraped feed does not configure a date format.
+var scrapDateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04",
+	"02/01/2006",
+	"January 2, 2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+}
+
+// scrapConfig holds the CSS selectors used to extract the items of a page for a feed of type 'scrap'.
+// It is stored as JSON in the rss_feeds.scrap_config column. Every selector except list is relative to an item.
+type scrapConfig struct {
+	List       string `json:"list"`        // Selector of the container of each item in the page.
+	Link       string `json:"link"`        // Selector of the link to the article, defaults to the first <a> of the item.
+	Title      string `json:"title"`       // Selector of the title, defaults to the text of the link.
+	Date       string `json:"date"`        // Selector of the publication date, its datetime attribute is used when present.
+	DateFormat string `json:"date_format"` // Go layout of the publication date, common layouts are tried when empty.
+	Summary    string `json:"summary"`     // Selector of the summary, whose inner HTML becomes the description of the item.
+	Interval   int    `json:"interval"`    // Polling interval in minutes when the items have no date, scrapDefaultInterval when 0.
+}
+
+// parseScrapConfig decodes and validates the scraping configuration of a feed.
+//
+// Parameters:
+//   - rawConfig: The JSON content of the rss_feeds.scrap_config column.
+//
+// Returns:
+//   - scrapConfig: The decoded configuration.
+//   - error: An error, if the configuration is missing, cannot be decoded or contains an invalid selector.
+func parseScrapConfig(rawConfig string) (scrapConfig, error) {
+	var config scrapConfig
+	if strings.TrimSpace(rawConfig) == "" {
+		return config, errors.New("the scrap_config column of the feed is empty")
+	}
+	if err := json.Unmarshal([]byte(rawConfig), &config); err != nil {
+		return config, fmt.Errorf("invalid scrap_config: %w", err)
+	}
+	if config.List == "" {
+		return config, errors.New("invalid scrap_config: the list selector is required")
+	}
+	if config.Interval < 0 {
+		return config, errors.New("invalid scrap_config: the interval must be positive")
+	}
+	if config.Interval == 0 {
+		config.Interval = scrapDefaultInterval
+	}
+
+	for name, selector := range map[string]string{"list": config.List, "link": config.Link, "title": config.Title, "date": config.Date, "summary": config.Summary} {
+		if selector == "" {
+			continue
+		}
+		if _, err := cascadia.ParseGroup(selector); err != nil {
+			return config, fmt.Errorf("invalid scrap_config: invalid %v selector %q: %w", name, selector, err)
+		}
+	}
+	return config, nil
+}
+
+// parseScrapDate parses a scraped publication date with the configured layout, or with common layouts.
+func parseScrapDate(value string, layout string) (time.Time, bool) {
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	layouts := scrapDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// scrapeFeed downloads a web page and extracts its items with the CSS selectors of the feed,
+// producing a feed with the same shape as a parsed RSS feed so that it flows through the same pipeline.
+// The link of an item is used as its GUID. The page is decoded from its declared charset before being parsed. When no
+// item has a date, the feed is polled at the interval of its configuration.
+//
+// Parameters:
+//   - ctx: The context of the fetch, cancelling it aborts the download.
+//   - pageUrl: The URL of the page to scrape.
+//   - rawConfig: The JSON scraping configuration of the feed.
+//
+// Returns:
+//   - *fetchResult: The scraped feed.
+//   - error: An error, if any, occurred while downloading or scraping the page.
+func scrapeFeed(ctx context.Context, pageUrl string, rawConfig string) (*fetchResult, error) {
+	config, err := parseScrapConfig(rawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	page, finalUrl, err := fetchHTMLPage(ctx, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(finalUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &gofeed.Feed{
+		Title:    strings.TrimSpace(doc.Find("title").First().Text()),
+		Link:     finalUrl,
+		FeedType: "scrap",
+	}
+
+	doc.Find(config.List).Each(func(_ int, selection *goquery.Selection) {
+		// Find the link of the item, the container itself may be the link.
+		linkSelection := selection.Find("a[href]").First()
+		if config.Link != "" {
+			linkSelection = selection.Find(config.Link).First()
+		} else if selection.Is("a[href]") {
+			linkSelection = selection
+		}
+		href, ok := linkSelection.Attr("href")
+		if !ok {
+			return
+		}
+		link, err := base.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+
+		item := &gofeed.Item{Link: link.String(), GUID: link.String()}
+
+		item.Title = strings.TrimSpace(linkSelection.Text())
+		if config.Title != "" {
+			item.Title = strings.TrimSpace(selection.Find(config.Title).First().Text())
+		}
+		item.Title = strings.Join(strings.Fields(item.Title), " ")
+
+		if config.Date != "" {
+			dateSelection := selection.Find(config.Date).First()
+			item.Published = dateSelection.AttrOr("datetime", dateSelection.Text())
+			if date, ok := parseScrapDate(item.Published, config.DateFormat); ok {
+				item.PublishedParsed = &date
+			}
+		}
+
+		if config.Summary != "" {
+			if summary, err := selection.Find(config.Summary).First().Html(); err == nil {
+				item.Description = strings.TrimSpace(summary)
+			}
+		}
+
+		feed.Items = append(feed.Items, item)
+	})
+
+	if len(feed.Items) == 0 {
+		return nil, fmt.Errorf("no item matched the list selector %q", config.List)
+	}
+
+	result := &fetchResult{Feed: feed, StatusCode: http.StatusOK}
+	if !slices.ContainsFunc(feed.Items, func(item *gofeed.Item) bool { return item.PublishedParsed != nil }) {
+		result.DefaultInterval = time.Duration(config.Interval) * time.Minute
+	}
+	return result, nil
+}
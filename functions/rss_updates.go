@@ -0,0 +1,153 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// fullTextCustomKey is the key of the Custom map of a feed item holding its full article, when it was downloaded.
+const fullTextCustomKey = "speakrine_full_text"
+
+// storedItem holds the state of an item already in the database, used to detect updates.
+type storedItem struct {
+	Id          int            // The ID of the item, 0 for an item deleted by the retention policy.
+	UpdatedDate sql.NullTime   // The updated date of the item.
+	ContentHash sql.NullString // The hash of the content of the item, as published by the feed.
+	Purged      bool           // Whether the item was archived or deleted by the retention policy, it is then left alone.
+}
+
+// itemRawContent returns the content to store for a feed item: its full article when it was downloaded,
+// otherwise its content, or its description when it has no content.
+func itemRawContent(item *gofeed.Item) string {
+	if fullText, ok := item.Custom[fullTextCustomKey]; ok {
+		return fullText
+	}
+	if item.Content == "" {
+		return item.Description
+	}
+	return item.Content
+}
+
+// itemContentHash computes the SHA-256 of the title, description and content of a feed item, as published by the feed.
+// The full article downloaded for feeds in full text mode is left out, so that it does not look like an update.
+//
+// Parameters:
+//   - item: The feed item.
+//
+// Returns:
+//   - string: The hexadecimal hash of the item.
+func itemContentHash(item *gofeed.Item) string {
+	sum := sha256.Sum256([]byte(item.Title + "\x00" + item.Description + "\x00" + item.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadStoredItems retrieves in a single query the state of every item of the given feed already in the database,
+// including the items purged by the retention policy, so that they are not ingested again.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - feedId: The ID of the feed.
+//
+// Returns:
+//   - map[string]storedItem: The state of the stored items, by identity key.
+//   - error: An error, if any, occurred during the database query.
+func loadStoredItems(db *sql.DB, feedId int) (map[string]storedItem, error) {
+	query := `
+		SELECT id, item_key, updated_date, content_hash, archived_at IS NOT NULL FROM rss_items WHERE rss_feed_id = ?
+		UNION ALL
+		SELECT 0, item_key, NULL, NULL, TRUE FROM rss_item_tombstones WHERE rss_feed_id = ?
+	`
+	rows, err := db.Query(query, feedId, feedId)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			slog.Error("Error closing rows", "Error", err)
+		}
+	}(rows)
+
+	items := make(map[string]storedItem)
+	for rows.Next() {
+		var stored storedItem
+		var itemKey string
+		if err := rows.Scan(&stored.Id, &itemKey, &stored.UpdatedDate, &stored.ContentHash, &stored.Purged); err != nil {
+			return nil, err
+		}
+		items[itemKey] = stored
+	}
+	return items, rows.Err()
+}
+
+// isContentChanged reports whether the content of a feed item differs from the stored one.
+// Items stored before content hashes were recorded are never considered changed.
+func isContentChanged(stored storedItem, item *gofeed.Item) bool {
+	return stored.ContentHash.Valid && stored.ContentHash.String != itemContentHash(item)
+}
+
+// isDateUpdated reports whether a feed item advertises a more recent updated date than the stored one.
+func isDateUpdated(stored storedItem, item *gofeed.Item) bool {
+	if item.UpdatedParsed == nil {
+		return false
+	}
+	incoming := item.UpdatedParsed.UTC().Truncate(time.Second)
+	return !stored.UpdatedDate.Valid || incoming.After(stored.UpdatedDate.Time)
+}
+
+// refreshStoredItem brings a stored item up to date with the version published by the feed.
+// When the content changed, the previous version is saved in rss_item_revisions, the raw content and the categories are replaced
+// and the formatted content and its review flag are reset so that the cleaning process runs again. When only the updated date
+// moved, it is refreshed alone. Items stored without a content hash get one.
+//
+// Parameters:
+//   - tx: The transaction in which the feed is ingested.
+//   - stored: The state of the stored item.
+//   - item: The item as published by the feed.
+//
+// Returns:
+//   - bool: Whether the content of the item changed.
+//   - error: An error, if any, occurred while updating the database.
+func refreshStoredItem(tx *sql.Tx, stored storedItem, item *gofeed.Item) (bool, error) {
+	if !stored.ContentHash.Valid {
+		_, err := tx.Exec("UPDATE rss_items SET content_hash = ? WHERE id = ?", itemContentHash(item), stored.Id)
+		return false, err
+	}
+
+	if !isContentChanged(stored, item) {
+		if !isDateUpdated(stored, item) {
+			return false, nil
+		}
+		_, err := tx.Exec("UPDATE rss_items SET updated_date = ? WHERE id = ?", item.UpdatedParsed, stored.Id)
+		return false, err
+	}
+
+	revisionQuery := `
+		INSERT INTO rss_item_revisions (rss_item_id, title, summary_raw, content_raw, content_formatted, updated_date, content_hash, revision_date)
+		SELECT id, title, summary_raw, content_raw, content_formatted, updated_date, content_hash, NOW()
+		FROM rss_items
+		WHERE id = ?
+	`
+	if _, err := tx.Exec(revisionQuery, stored.Id); err != nil {
+		return false, err
+	}
+
+	updateQuery := `
+		UPDATE rss_items
+		SET title = ?, summary_raw = ?, content_raw = ?, updated_date = COALESCE(?, NOW()), content_hash = ?, categories = ?,
+			summary_formatted = NULL, content_formatted = NULL,
+			clean_status = ?, clean_attempts = 0, clean_error = NULL, clean_claimed_by = NULL,
+			needs_review = FALSE, fidelity_issue = NULL
+		WHERE id = ?
+	`
+	if _, err := tx.Exec(updateQuery, truncateText(item.Title, maxItemTitleLength), truncateText(item.Description, maxItemTextLength), itemRawContent(item), item.UpdatedParsed, itemContentHash(item), categoriesJSON(itemCategories(item)), cleanStatusPending, stored.Id); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,177 @@
+package functions
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cl3mcg/speakrine/databases"
+)
+
+// feedSource holds the information needed to fetch a feed stored in the rss_feeds table.
+type feedSource struct {
+	Id                  int             // The ID of the feed.
+	UserId              int             // The ID of the user subscribed to the feed.
+	Url                 string          // The URL of the feed.
+	FeedType            string          // The type of the feed (rss, atom, json, scrap, other).
+	ScrapConfig         string          // The JSON scraping configuration of a feed of type 'scrap'.
+	FetchFullText       bool            // Whether the full article of new items is downloaded from their link.
+	ETag                string          // The ETag header returned by the previous fetch.
+	LastModified        string          // The Last-Modified header returned by the previous fetch.
+	FetchInterval       time.Duration   // The polling interval computed at the previous fetch.
+	NextFetchAt         time.Time       // The time at which the feed is scheduled to be fetched next.
+	ConsecutiveFailures int             // The number of fetches that failed in a row.
+	RedirectUrl         string          // The URL the feed was permanently redirected to at the previous fetches.
+	RedirectCount       int             // The number of fetches in a row redirected to RedirectUrl.
+	SiteLink            string          // The URL of the website of the feed stored at the previous fetch.
+	IconCheckedAt       time.Time       // The last time the favicon of the website was looked up.
+	Credentials         feedCredentials // The basic auth credentials and custom headers of a private feed.
+	WebSubActive        bool            // Whether the feed has an active WebSub subscription, its updates being pushed by its hub.
+}
+
+// feedFetchOutcome holds the outcome of the fetch of a single feed by a worker.
+type feedFetchOutcome struct {
+	Source feedSource   // The feed that was fetched.
+	Result *fetchResult // The result of the fetch, nil if an error occurred.
+	Err    error        // The error, if any, occurred while fetching the feed.
+}
+
+// hostLimiter caps the number of concurrent requests sent to the same host.
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+// newHostLimiter creates a hostLimiter allowing up to limit concurrent requests per host.
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// semaphore returns the semaphore channel associated with the given host, creating it if needed.
+func (l *hostLimiter) semaphore(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	return slot
+}
+
+// acquire blocks until a request slot is available for the given host.
+func (l *hostLimiter) acquire(host string) {
+	l.semaphore(host) <- struct{}{}
+}
+
+// release frees a request slot previously acquired for the given host.
+func (l *hostLimiter) release(host string) {
+	<-l.semaphore(host)
+}
+
+// feedHost returns the lower-cased host name of a feed URL, or the raw URL if it cannot be parsed.
+func feedHost(rawUrl string) string {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil || parsedUrl.Hostname() == "" {
+		return rawUrl
+	}
+	return strings.ToLower(parsedUrl.Hostname())
+}
+
+// interleaveByHost reorders the feeds so that feeds hosted on the same domain are spread out,
+// which keeps the workers busy with other hosts while a host is at its concurrency cap.
+//
+// Parameters:
+//   - sources: The feeds to reorder.
+//
+// Returns:
+//   - []feedSource: The feeds ordered in a round-robin fashion over their hosts.
+func interleaveByHost(sources []feedSource) []feedSource {
+	var hosts []string
+	byHost := make(map[string][]feedSource)
+	for _, source := range sources {
+		host := feedHost(source.Url)
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], source)
+	}
+
+	ordered := make([]feedSource, 0, len(sources))
+	for len(ordered) < len(sources) {
+		for _, host := range hosts {
+			if len(byHost[host]) > 0 {
+				ordered = append(ordered, byHost[host][0])
+				byHost[host] = byHost[host][1:]
+			}
+		}
+	}
+	return ordered
+}
+
+// fetchFeedsConcurrently fetches the given feeds with a bounded pool of workers.
+// At most concurrency feeds are fetched at the same time, and at most perHost of them target the same host.
+// The outcomes are sent on the returned channel, which is closed once every feed has been fetched,
+// so that a single reader can write them to the database. The workers also look up the favicon of the website
+// of the feeds when it is due and, for feeds in full text mode, download the full article of the new items,
+// which only requires reading from the database.
+// When the context is cancelled, no new feed is handed to the workers and the pending fetches fail with context.Canceled.
+//
+// Parameters:
+//   - ctx: The context of the run.
+//   - sources: The feeds to fetch.
+//   - concurrency: The number of fetch workers.
+//   - perHost: The maximum number of concurrent fetches for a single host.
+//
+// Returns:
+//   - <-chan feedFetchOutcome: The channel on which the outcome of each fetch is sent.
+func fetchFeedsConcurrently(ctx context.Context, sources []feedSource, concurrency int, perHost int) <-chan feedFetchOutcome {
+	jobs := make(chan feedSource)
+	outcomes := make(chan feedFetchOutcome)
+	limiter := newHostLimiter(perHost)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for source := range jobs {
+				host := feedHost(source.Url)
+				limiter.acquire(host)
+				result, err := fetchFeedSource(ctx, source)
+				limiter.release(host)
+				if err == nil && result.Feed != nil {
+					refreshFeedIcon(ctx, source, result)
+					if source.FetchFullText {
+						fetchFullTextContent(ctx, databases.GetDB(), source.Id, result.Feed)
+					}
+				}
+				if ctx.Err() != nil {
+					// Report aborted fetches as such, whatever the error surfaced by the request.
+					result, err = nil, ctx.Err()
+				}
+				outcomes <- feedFetchOutcome{Source: source, Result: result, Err: err}
+			}
+		}()
+	}
+
+	// Feed the workers until the context is cancelled, then close the outcomes channel once they are all done.
+	go func() {
+		defer close(outcomes)
+		defer wg.Wait()
+		defer close(jobs)
+		for _, source := range interleaveByHost(sources) {
+			select {
+			case jobs <- source:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outcomes
+}
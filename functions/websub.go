@@ -0,0 +1,592 @@
+package functions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cl3mcg/speakrine/databases"
+	gowebly "github.com/gowebly/helpers"
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+)
+
+// WebSub subscription states, stored in the websub_subscriptions.state column.
+const (
+	websubStateNew     = "new"     // The hub was discovered, the subscription has not been requested yet.
+	websubStatePending = "pending" // The subscription was requested, the hub has not verified it yet.
+	websubStateActive  = "active"  // The hub verified the subscription and pushes the updates of the feed.
+	websubStateDenied  = "denied"  // The hub denied the subscription.
+	websubStateExpired = "expired" // The lease lapsed without being renewed, the feed is polled again.
+)
+
+// websubRetryDelay is the time after which a failed, unanswered or denied subscription request is sent again.
+const websubRetryDelay = time.Hour
+
+// websubCallbackBase returns the public URL under which the hubs reach the callback endpoint, from WEBSUB_CALLBACK_URL.
+// WebSub is disabled when it is not set.
+func websubCallbackBase() string {
+	return strings.TrimSuffix(strings.TrimSpace(gowebly.Getenv("WEBSUB_CALLBACK_URL", "")), "/")
+}
+
+// websubEnabled reports whether the WebSub subscriber is configured.
+func websubEnabled() bool {
+	return websubCallbackBase() != "" && strings.TrimSpace(gowebly.Getenv("WEBSUB_LISTEN_ADDR", "")) != ""
+}
+
+// websubCallbackUrl returns the callback URL of the subscription of a feed.
+func websubCallbackUrl(feedId int) string {
+	return websubCallbackBase() + "/" + strconv.Itoa(feedId)
+}
+
+// hubAtomTranslator is a gofeed Atom translator that keeps the <link rel="hub"> of the feed in the Custom map
+// of the universal feed, as gofeed drops the relation of the links otherwise.
+type hubAtomTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+// Translate converts an atom.Feed into the universal gofeed.Feed and keeps its hub.
+func (t *hubAtomTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	atomFeed, ok := feed.(*atom.Feed)
+	if !ok {
+		return result, nil
+	}
+	for _, link := range atomFeed.Links {
+		if strings.EqualFold(link.Rel, "hub") && link.Href != "" {
+			if result.Custom == nil {
+				result.Custom = make(map[string]string)
+			}
+			result.Custom["hub"] = link.Href
+			break
+		}
+	}
+	return result, nil
+}
+
+// feedHub returns the hub advertised by a feed document: the <link rel="hub"> of an Atom feed,
+// or the <atom:link rel="hub"> of an RSS channel.
+func feedHub(feed *gofeed.Feed) string {
+	if hub := feed.Custom["hub"]; hub != "" {
+		return hub
+	}
+	for _, prefix := range []string{"atom", "atom10", "atom03"} {
+		for _, link := range feed.Extensions[prefix]["link"] {
+			if strings.EqualFold(link.Attrs["rel"], "hub") && link.Attrs["href"] != "" {
+				return link.Attrs["href"]
+			}
+		}
+	}
+	return ""
+}
+
+// parseLinkHeader parses the Link headers of a response and returns the first URL of each relation.
+//
+// Parameters:
+//   - values: The values of the Link headers.
+//   - base: The URL of the response, against which relative URLs are resolved.
+//
+// Returns:
+//   - map[string]string: The absolute URLs, by lower-cased relation.
+func parseLinkHeader(values []string, base *url.URL) map[string]string {
+	links := make(map[string]string)
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			linkUrl, err := base.Parse(strings.Trim(target, "<>"))
+			if err != nil {
+				continue
+			}
+			for _, param := range parts[1:] {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`))) {
+					if _, ok := links[rel]; !ok {
+						links[rel] = linkUrl.String()
+					}
+				}
+			}
+		}
+	}
+	return links
+}
+
+// websubLinks returns the hub and the topic of a fetched feed. The Link headers of the response take precedence
+// over the links of the document, and the topic defaults to the URL of the feed.
+func websubLinks(source feedSource, result *fetchResult) (string, string) {
+	hub, topic := result.HubUrl, result.TopicUrl
+	if hub == "" {
+		hub = feedHub(result.Feed)
+	}
+	if topic == "" {
+		topic = strings.TrimSpace(result.Feed.FeedLink)
+	}
+	if topic == "" {
+		topic = source.Url
+	}
+
+	// The links of the document may be relative to the URL of the feed.
+	if base, err := url.Parse(source.Url); err == nil {
+		if hubUrl, err := base.Parse(strings.TrimSpace(hub)); err == nil && hub != "" {
+			hub = hubUrl.String()
+		}
+		if topicUrl, err := base.Parse(topic); err == nil {
+			topic = topicUrl.String()
+		}
+	}
+	return strings.TrimSpace(hub), topic
+}
+
+// newWebSubSecret generates the secret a hub uses to sign the content it pushes.
+func newWebSubSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// updateWebSubSubscription records the hub advertised by a fetched feed, so that the subscription is requested
+// by the next renewal. A subscription whose hub or topic changed is requested again, and the subscription
+// of a feed that no longer advertises a hub is dropped, its lease lapsing on the side of the hub.
+//
+// Parameters:
+//   - db: The database connection instance.
+//   - source: The feed that was fetched.
+//   - result: The result of the fetch.
+//
+// Returns:
+//   - error: An error, if any, occurred while updating the database.
+func updateWebSubSubscription(db *sql.DB, source feedSource, result *fetchResult) error {
+	hub, topic := websubLinks(source, result)
+	if hub == "" {
+		_, err := db.Exec("DELETE FROM websub_subscriptions WHERE rss_feed_id = ?", source.Id)
+		return err
+	}
+
+	secret, err := newWebSubSecret()
+	if err != nil {
+		return err
+	}
+
+	// The state and the time of the last request are computed before the hub and topic are overwritten.
+	query := `
+		INSERT INTO websub_subscriptions (rss_feed_id, hub_url, topic_url, secret, state)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			state = IF(hub_url <> VALUES(hub_url) OR topic_url <> VALUES(topic_url), VALUES(state), state),
+			requested_at = IF(hub_url <> VALUES(hub_url) OR topic_url <> VALUES(topic_url), NULL, requested_at),
+			hub_url = VALUES(hub_url),
+			topic_url = VALUES(topic_url)
+	`
+	_, err = db.Exec(query, source.Id, hub, topic, secret, websubStateNew)
+	return err
+}
+
+// websubSubscription is a row of the websub_subscriptions table.
+type websubSubscription struct {
+	FeedId   int    // The ID of the subscribed feed.
+	HubUrl   string // The URL of the hub.
+	TopicUrl string // The URL of the topic, the self URL of the feed.
+	Secret   string // The secret used by the hub to sign the content it pushes.
+	State    string // The state of the subscription.
+}
+
+// loadWebSubSubscription retrieves the subscription of a feed.
+// It returns sql.ErrNoRows if the feed has no subscription.
+func loadWebSubSubscription(db *sql.DB, feedId int) (websubSubscription, error) {
+	subscription := websubSubscription{FeedId: feedId}
+	err := db.QueryRow("SELECT hub_url, topic_url, secret, state FROM websub_subscriptions WHERE rss_feed_id = ?", feedId).
+		Scan(&subscription.HubUrl, &subscription.TopicUrl, &subscription.Secret, &subscription.State)
+	return subscription, err
+}
+
+// requestWebSubSubscription sends a subscription request to the hub of a feed.
+// The hub answers 202 Accepted and verifies the request asynchronously on the callback endpoint.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - subscription: The subscription to request.
+//
+// Returns:
+//   - error: An error, if any, occurred while sending the request or if the hub refused it.
+func requestWebSubSubscription(ctx context.Context, subscription websubSubscription) error {
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {subscription.TopicUrl},
+		"hub.callback":      {websubCallbackUrl(subscription.FeedId)},
+		"hub.secret":        {subscription.Secret},
+		"hub.lease_seconds": {strconv.Itoa(getenvInt("WEBSUB_LEASE_SECONDS", 864000))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.HubUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", httpSettings().UserAgent)
+
+	resp, err := politeDo(pageHTTPClient(), req)
+	if err != nil {
+		return err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %v", httpStatusError(resp), strings.TrimSpace(string(reason)))
+	}
+	return nil
+}
+
+// RenewWebSubSubscriptions requests the subscriptions to the hubs discovered since the last run, renews the leases
+// about to lapse, and sends again the requests the hubs did not verify. The feeds whose lease lapsed are
+// scheduled to be polled right away. It does nothing when WebSub is not configured.
+//
+// Parameters:
+//   - ctx: The context of the run, cancelled when the process shuts down.
+//
+// Returns:
+//   - error: An error, if any, occurred while querying the database.
+func RenewWebSubSubscriptions(ctx context.Context) error {
+	if !websubEnabled() {
+		return nil
+	}
+	db := databases.GetDB()
+
+	// Fall back to polling for the feeds whose lease lapsed.
+	query := `
+		UPDATE rss_feeds
+		JOIN websub_subscriptions ON websub_subscriptions.rss_feed_id = rss_feeds.id
+		SET rss_feeds.next_fetch_at = NULL, websub_subscriptions.state = ?
+		WHERE websub_subscriptions.state = ? AND websub_subscriptions.lease_expires_at <= NOW()
+	`
+	if _, err := db.Exec(query, websubStateExpired, websubStateActive); err != nil {
+		slog.Error("Error expiring WebSub subscriptions", "Error", err)
+		return err
+	}
+
+	// Leases are renewed when less than a day, or half of the lease, is left. A failed or unanswered request is only
+	// sent again after websubRetryDelay, so that a hub that is down or refusing is not contacted at every cycle.
+	query = `
+		SELECT rss_feed_id, hub_url, topic_url, secret, state
+		FROM websub_subscriptions
+		WHERE (state IN (?, ?, ?, ?) AND (requested_at IS NULL OR requested_at <= NOW() - INTERVAL ? SECOND))
+			OR (state = ? AND lease_expires_at <= NOW() + INTERVAL LEAST(86400, lease_seconds DIV 2) SECOND
+				AND (requested_at IS NULL OR requested_at <= NOW() - INTERVAL ? SECOND))
+	`
+	retryDelay := int(websubRetryDelay.Seconds())
+	rows, err := db.Query(query, websubStateNew, websubStateExpired, websubStatePending, websubStateDenied, retryDelay, websubStateActive, retryDelay)
+	if err != nil {
+		slog.Error("Error querying websub_subscriptions", "Error", err)
+		return err
+	}
+	var subscriptions []websubSubscription
+	for rows.Next() {
+		var subscription websubSubscription
+		if err := rows.Scan(&subscription.FeedId, &subscription.HubUrl, &subscription.TopicUrl, &subscription.Secret, &subscription.State); err != nil {
+			_ = rows.Close()
+			slog.Error("Error scanning websub_subscriptions row", "Error", err)
+			return err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// An active subscription stays active while its renewal is verified.
+		state := subscription.State
+		requestErr := requestWebSubSubscription(ctx, subscription)
+		if requestErr != nil {
+			slog.Warn("Error requesting WebSub subscription", "Feed ID", subscription.FeedId, "Hub URL", subscription.HubUrl, "Error", requestErr)
+		} else if state != websubStateActive {
+			state = websubStatePending
+		}
+
+		var lastError sql.NullString
+		if requestErr != nil {
+			lastError = sql.NullString{String: requestErr.Error(), Valid: true}
+		}
+		query := "UPDATE websub_subscriptions SET state = ?, requested_at = NOW(), last_error = ? WHERE rss_feed_id = ?"
+		if _, err := db.Exec(query, state, lastError, subscription.FeedId); err != nil {
+			slog.Error("Error updating websub_subscriptions row", "Feed ID", subscription.FeedId, "Error", err)
+		}
+	}
+	return nil
+}
+
+// validWebSubSignature checks the X-Hub-Signature header of pushed content against the secret of the subscription.
+func validWebSubSignature(signature string, body []byte, secret string) bool {
+	method, value, ok := strings.Cut(strings.TrimSpace(signature), "=")
+	if !ok {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(method) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(value)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// websubHandler serves the callback endpoint of the WebSub subscriptions, at WEBSUB_CALLBACK_URL/{feed_id}.
+type websubHandler struct {
+	db     *sql.DB
+	ctx    context.Context // The context of the process, under which the pushed content is ingested.
+	pushes *sync.WaitGroup // The ingestions of pushed content still running.
+}
+
+// ServeHTTP answers the verification requests of the hubs (GET) and ingests the content they push (POST).
+func (h websubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	feedId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	subscription, err := loadWebSubSubscription(h.db, feedId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.Error("Error loading WebSub subscription", "Feed ID", feedId, "Error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.verify(w, r, subscription)
+	case http.MethodPost:
+		h.receive(w, r, subscription)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers the verification of intent of a hub. Only the subscriptions that were requested are confirmed or denied.
+func (h websubHandler) verify(w http.ResponseWriter, r *http.Request, subscription websubSubscription) {
+	query := r.URL.Query()
+	if query.Get("hub.topic") != subscription.TopicUrl {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		if subscription.State != websubStatePending && subscription.State != websubStateActive {
+			http.NotFound(w, r)
+			return
+		}
+		leaseSeconds, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil || leaseSeconds < 1 {
+			leaseSeconds = getenvInt("WEBSUB_LEASE_SECONDS", 864000)
+		}
+		update := `
+			UPDATE websub_subscriptions
+			SET state = ?, lease_seconds = ?, lease_expires_at = NOW() + INTERVAL ? SECOND, last_error = NULL
+			WHERE rss_feed_id = ?
+		`
+		if _, err := h.db.Exec(update, websubStateActive, leaseSeconds, leaseSeconds, subscription.FeedId); err != nil {
+			slog.Error("Error activating WebSub subscription", "Feed ID", subscription.FeedId, "Error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("WebSub subscription verified", "Feed ID", subscription.FeedId, "Hub URL", subscription.HubUrl, "Lease", time.Duration(leaseSeconds)*time.Second)
+	case "denied":
+		// A hub only denies a subscription that was requested, and the denial is not signed: any other is ignored.
+		if subscription.State != websubStatePending {
+			http.NotFound(w, r)
+			return
+		}
+		// Poll the feed again right away.
+		update := `
+			UPDATE rss_feeds
+			JOIN websub_subscriptions ON websub_subscriptions.rss_feed_id = rss_feeds.id
+			SET rss_feeds.next_fetch_at = NULL, websub_subscriptions.state = ?, websub_subscriptions.last_error = NULLIF(?, '')
+			WHERE rss_feeds.id = ?
+		`
+		if _, err := h.db.Exec(update, websubStateDenied, query.Get("hub.reason"), subscription.FeedId); err != nil {
+			slog.Error("Error recording denied WebSub subscription", "Feed ID", subscription.FeedId, "Error", err)
+		}
+		slog.Warn("WebSub subscription denied", "Feed ID", subscription.FeedId, "Hub URL", subscription.HubUrl, "Reason", query.Get("hub.reason"))
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		// The subscriber never requests unsubscriptions, it lets the leases lapse.
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = io.WriteString(w, query.Get("hub.challenge"))
+}
+
+// receive acknowledges the content pushed by a hub once its signature is verified, then ingests it in the background
+// through the same path as a polled feed: downloading the full text of the entries can take longer than the hub waits,
+// and a hub that times out delivers the content again.
+// Content whose signature does not match the secret of the subscription is acknowledged but ignored, as required by WebSub.
+func (h websubHandler) receive(w http.ResponseWriter, r *http.Request, subscription websubSubscription) {
+	if subscription.State != websubStateActive {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, httpSettings().MaxBodySize))
+	if err != nil {
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+	if !validWebSubSignature(r.Header.Get("X-Hub-Signature"), body, subscription.Secret) {
+		slog.Warn("Ignoring WebSub content with an invalid signature", "Feed ID", subscription.FeedId)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	h.pushes.Add(1)
+	go func() {
+		defer h.pushes.Done()
+		h.ingestPush(subscription, body, contentType)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ingestPush ingests the content pushed by a hub, keeping the polling schedule and the caching headers of the feed.
+//
+// Parameters:
+//   - subscription: The subscription the content was pushed for.
+//   - body: The pushed feed document, whose signature was verified.
+//   - contentType: The Content-Type header of the push.
+func (h websubHandler) ingestPush(subscription websubSubscription, body []byte, contentType string) {
+	feed, repairs, err := parseFeedBody(body, contentType)
+	if err != nil {
+		slog.Warn("Ignoring WebSub content that cannot be parsed", "Feed ID", subscription.FeedId, "Error", err)
+		return
+	}
+
+	sources, err := queryFeedSources(h.db, "id = ? AND status = ?", subscription.FeedId, feedStatusActive)
+	if err != nil {
+		slog.Error("Error querying the feed of WebSub content", "Feed ID", subscription.FeedId, "Error", err)
+		return
+	}
+	if len(sources) == 0 {
+		slog.Warn("Ignoring WebSub content of a feed that is not active", "Feed ID", subscription.FeedId)
+		return
+	}
+	source := sources[0]
+
+	if source.FetchFullText {
+		fetchFullTextContent(h.ctx, h.db, source.Id, feed)
+	}
+
+	minInterval, _ := fetchIntervalBounds()
+	interval := max(source.FetchInterval, minInterval)
+	nextFetchAt := source.NextFetchAt
+	if nextFetchAt.IsZero() {
+		nextFetchAt = time.Now().Add(interval)
+	}
+	result := &fetchResult{Feed: feed, ETag: source.ETag, LastModified: source.LastModified, StatusCode: http.StatusOK, Repairs: repairs}
+	if err := ingestFeedItems(h.db, source, result, interval, nextFetchAt); err != nil {
+		slog.Error("Error ingesting WebSub content", "Feed ID", source.Id, "Error", err)
+		return
+	}
+	if _, err := h.db.Exec("UPDATE websub_subscriptions SET last_push_at = NOW() WHERE rss_feed_id = ?", source.Id); err != nil {
+		slog.Error("Error updating websub_subscriptions row", "Feed ID", source.Id, "Error", err)
+	}
+}
+
+// StartWebSubServer starts the callback endpoint of the WebSub subscriptions on WEBSUB_LISTEN_ADDR, in the background.
+// The server is shut down when the context is cancelled. It does nothing when WebSub is not configured.
+//
+// Parameters:
+//   - ctx: The context of the process.
+//
+// Returns:
+//   - error: An error if WEBSUB_CALLBACK_URL is not a valid URL.
+func StartWebSubServer(ctx context.Context) error {
+	if !websubEnabled() {
+		return nil
+	}
+	callbackUrl, err := url.Parse(websubCallbackBase())
+	if err != nil || callbackUrl.Host == "" {
+		return fmt.Errorf("invalid WEBSUB_CALLBACK_URL %q", websubCallbackBase())
+	}
+
+	handler := websubHandler{db: databases.GetDB(), ctx: ctx, pushes: &sync.WaitGroup{}}
+	mux := http.NewServeMux()
+	mux.Handle(strings.TrimSuffix(callbackUrl.Path, "/")+"/{id}", handler)
+	server := &http.Server{
+		Addr:              strings.TrimSpace(gowebly.Getenv("WEBSUB_LISTEN_ADDR", "")),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		slog.Info("Starting the WebSub callback server", "Address", server.Addr, "Callback URL", websubCallbackBase())
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("The WebSub callback server has failed", "Error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+		// Let the pushed content being ingested reach the database.
+		handler.pushes.Wait()
+	}()
+	return nil
+}
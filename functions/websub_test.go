@@ -0,0 +1,378 @@
+package functions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql/driver"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sign returns the X-Hub-Signature header of a body, as a hub would compute it.
+func sign(method string, newHash func() hash.Hash, secret string, body string) string {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(body))
+	return method + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidWebSubSignature(t *testing.T) {
+	const secret = "0123456789abcdef"
+	const body = `<feed xmlns="http://www.w3.org/2005/Atom"></feed>`
+
+	tests := []struct {
+		name      string
+		signature string
+		secret    string
+		want      bool
+	}{
+		{"sha1", sign("sha1", sha1.New, secret, body), secret, true},
+		{"sha256", sign("sha256", sha256.New, secret, body), secret, true},
+		{"sha384", sign("sha384", sha512.New384, secret, body), secret, true},
+		{"sha512", sign("sha512", sha512.New, secret, body), secret, true},
+		{"upper-case method", sign("SHA256", sha256.New, secret, body), secret, true},
+		{"signed with another secret", sign("sha256", sha256.New, "another secret", body), secret, false},
+		{"signature of another body", sign("sha256", sha256.New, secret, body+" "), secret, false},
+		{"method mismatching the hash", sign("sha512", sha256.New, secret, body), secret, false},
+		{"unsupported method", sign("md5", sha256.New, secret, body), secret, false},
+		{"not hexadecimal", "sha256=not-hex", secret, false},
+		{"missing method", hex.EncodeToString([]byte("signature")), secret, false},
+		{"missing signature", "", secret, false},
+		{"missing secret", sign("sha256", sha256.New, secret, body), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validWebSubSignature(tt.signature, []byte(body), tt.secret); got != tt.want {
+				t.Errorf("validWebSubSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/feed.xml")
+
+	tests := []struct {
+		name   string
+		values []string
+		want   map[string]string
+	}{
+		{
+			name:   "hub and self",
+			values: []string{`<https://hub.example.net/>; rel="hub", <https://example.com/feed>; rel="self"`},
+			want:   map[string]string{"hub": "https://hub.example.net/", "self": "https://example.com/feed"},
+		},
+		{
+			name:   "several headers",
+			values: []string{`<https://hub.example.net/>; rel=hub`, `<https://example.com/feed>; rel=self`},
+			want:   map[string]string{"hub": "https://hub.example.net/", "self": "https://example.com/feed"},
+		},
+		{
+			name:   "relative URL",
+			values: []string{`</websub/hub>; rel="hub"`},
+			want:   map[string]string{"hub": "https://example.com/websub/hub"},
+		},
+		{
+			name:   "several relations, case-folded",
+			values: []string{`<https://example.com/feed>; rel="Self Alternate"`},
+			want:   map[string]string{"self": "https://example.com/feed", "alternate": "https://example.com/feed"},
+		},
+		{
+			name:   "first URL of a relation wins",
+			values: []string{`<https://hub1.example.net/>; rel="hub", <https://hub2.example.net/>; rel="hub"`},
+			want:   map[string]string{"hub": "https://hub1.example.net/"},
+		},
+		{
+			name:   "other parameters",
+			values: []string{`<https://hub.example.net/>; title="Hub"; rel="hub"`},
+			want:   map[string]string{"hub": "https://hub.example.net/"},
+		},
+		{
+			name:   "malformed links",
+			values: []string{`https://hub.example.net/; rel="hub"`, `<https://example.com/feed>`},
+			want:   map[string]string{},
+		},
+		{
+			name:   "no header",
+			values: nil,
+			want:   map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLinkHeader(tt.values, base)
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseLinkHeader() = %v, want %v", got, tt.want)
+			}
+			for rel, want := range tt.want {
+				if got[rel] != want {
+					t.Errorf("parseLinkHeader()[%q] = %q, want %q", rel, got[rel], want)
+				}
+			}
+		})
+	}
+}
+
+const (
+	testTopicUrl = "https://example.com/feed"
+	testSecret   = "0123456789abcdef"
+)
+
+// newWebSubTestServer serves the callback endpoint of a subscription in the given state, backed by a fakeDB.
+// The returned wait group is done once the pushed content has been ingested.
+func newWebSubTestServer(t *testing.T, state string) (*httptest.Server, *fakeDB, *sync.WaitGroup) {
+	t.Helper()
+	db, fake := newFakeDB(t)
+	fake.answer("SELECT hub_url, topic_url, secret, state", []string{"hub_url", "topic_url", "secret", "state"},
+		[]driver.Value{"https://hub.example.net/", testTopicUrl, testSecret, state})
+
+	pushes := &sync.WaitGroup{}
+	mux := http.NewServeMux()
+	mux.Handle("/websub/{id}", websubHandler{db: db, ctx: context.Background(), pushes: pushes})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	t.Cleanup(pushes.Wait)
+	return server, fake, pushes
+}
+
+// verifyIntent sends the verification of intent of a hub to the callback endpoint of feed 42.
+func verifyIntent(t *testing.T, server *httptest.Server, params url.Values) (int, string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/websub/42?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestWebSubVerify(t *testing.T) {
+	tests := []struct {
+		name          string
+		state         string
+		params        url.Values
+		wantStatus    int
+		wantChallenge bool
+		wantUpdate    string
+	}{
+		{
+			name:          "subscription requested",
+			state:         websubStatePending,
+			params:        url.Values{"hub.mode": {"subscribe"}, "hub.topic": {testTopicUrl}, "hub.challenge": {"c4ll3ng3"}, "hub.lease_seconds": {"3600"}},
+			wantStatus:    http.StatusOK,
+			wantChallenge: true,
+			wantUpdate:    "UPDATE websub_subscriptions",
+		},
+		{
+			name:          "renewal of an active subscription",
+			state:         websubStateActive,
+			params:        url.Values{"hub.mode": {"subscribe"}, "hub.topic": {testTopicUrl}, "hub.challenge": {"c4ll3ng3"}},
+			wantStatus:    http.StatusOK,
+			wantChallenge: true,
+			wantUpdate:    "UPDATE websub_subscriptions",
+		},
+		{
+			name:       "subscription not requested",
+			state:      websubStateNew,
+			params:     url.Values{"hub.mode": {"subscribe"}, "hub.topic": {testTopicUrl}, "hub.challenge": {"c4ll3ng3"}},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "another topic",
+			state:      websubStatePending,
+			params:     url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://example.com/other"}, "hub.challenge": {"c4ll3ng3"}},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "unsubscription",
+			state:      websubStateActive,
+			params:     url.Values{"hub.mode": {"unsubscribe"}, "hub.topic": {testTopicUrl}, "hub.challenge": {"c4ll3ng3"}},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "denial of a requested subscription",
+			state:      websubStatePending,
+			params:     url.Values{"hub.mode": {"denied"}, "hub.topic": {testTopicUrl}, "hub.reason": {"not allowed"}},
+			wantStatus: http.StatusOK,
+			wantUpdate: "UPDATE rss_feeds",
+		},
+		{
+			name:       "denial of an active subscription",
+			state:      websubStateActive,
+			params:     url.Values{"hub.mode": {"denied"}, "hub.topic": {testTopicUrl}},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "denial of another topic",
+			state:      websubStatePending,
+			params:     url.Values{"hub.mode": {"denied"}, "hub.topic": {"https://example.com/other"}},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, fake, _ := newWebSubTestServer(t, tt.state)
+			status, body := verifyIntent(t, server, tt.params)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantChallenge && body != tt.params.Get("hub.challenge") {
+				t.Errorf("body = %q, want the challenge %q", body, tt.params.Get("hub.challenge"))
+			}
+
+			updates := len(fake.executed("UPDATE"))
+			if tt.wantUpdate == "" && updates > 0 {
+				t.Errorf("the subscription was updated, want it untouched")
+			}
+			if tt.wantUpdate != "" && len(fake.executed(tt.wantUpdate)) != 1 {
+				t.Errorf("want one %q statement, got %d updates", tt.wantUpdate, updates)
+			}
+		})
+	}
+}
+
+const testPushedFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Example</title>
+	<link rel="self" href="https://example.com/feed"/>
+	<entry>
+		<id>urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</id>
+		<title>Pushed entry</title>
+		<link href="https://example.com/pushed-entry"/>
+		<updated>2024-05-01T12:00:00Z</updated>
+		<content type="html">&lt;p&gt;The content of the pushed entry.&lt;/p&gt;</content>
+	</entry>
+</feed>`
+
+// pushContent sends the content a hub distributes to the callback endpoint of feed 42.
+func pushContent(t *testing.T, server *httptest.Server, body string, signature string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/websub/42", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/atom+xml")
+	if signature != "" {
+		req.Header.Set("X-Hub-Signature", signature)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+// answerFeedSource makes the fakeDB answer the query of the feed sources with an active feed 42.
+func answerFeedSource(fake *fakeDB) {
+	columns := []string{"id", "user_id", "url", "type", "scrap_config", "fetch_full_text", "etag", "last_modified", "fetch_interval",
+		"next_fetch_at", "consecutive_failures", "redirect_url", "redirect_count", "site_link", "icon_checked_at",
+		"auth_username", "auth_password", "request_headers", "websub_active"}
+	fake.answer("auth_username", columns, []driver.Value{
+		int64(42), int64(1), testTopicUrl, "atom", nil, false, nil, nil, int64(60),
+		time.Now().Add(time.Hour), int64(0), nil, int64(0), "https://example.com", time.Now(),
+		nil, nil, nil, true,
+	})
+}
+
+func TestWebSubReceive(t *testing.T) {
+	t.Run("signed content is ingested", func(t *testing.T) {
+		server, fake, pushes := newWebSubTestServer(t, websubStateActive)
+		answerFeedSource(fake)
+
+		if status := pushContent(t, server, testPushedFeed, sign("sha256", sha256.New, testSecret, testPushedFeed)); status != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d", status, http.StatusAccepted)
+		}
+		pushes.Wait()
+		inserts := fake.executed("INSERT INTO rss_items")
+		if len(inserts) != 1 {
+			t.Fatalf("want the pushed entry inserted once, got %d inserts", len(inserts))
+		}
+		if title := inserts[0].Args[1]; title != "Pushed entry" {
+			t.Errorf("inserted title = %v, want %q", title, "Pushed entry")
+		}
+		if len(fake.executed("last_push_at")) != 1 {
+			t.Errorf("want the push recorded on the subscription")
+		}
+	})
+
+	t.Run("content with an invalid signature is acknowledged and ignored", func(t *testing.T) {
+		server, fake, pushes := newWebSubTestServer(t, websubStateActive)
+		answerFeedSource(fake)
+
+		if status := pushContent(t, server, testPushedFeed, sign("sha256", sha256.New, "another secret", testPushedFeed)); status != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d", status, http.StatusAccepted)
+		}
+		pushes.Wait()
+		if len(fake.executed("INSERT INTO rss_items")) != 0 {
+			t.Errorf("content with an invalid signature was ingested")
+		}
+	})
+
+	t.Run("unsigned content is acknowledged and ignored", func(t *testing.T) {
+		server, fake, pushes := newWebSubTestServer(t, websubStateActive)
+		answerFeedSource(fake)
+
+		if status := pushContent(t, server, testPushedFeed, ""); status != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d", status, http.StatusAccepted)
+		}
+		pushes.Wait()
+		if len(fake.executed("INSERT INTO rss_items")) != 0 {
+			t.Errorf("unsigned content was ingested")
+		}
+	})
+
+	t.Run("content of an inactive subscription is refused", func(t *testing.T) {
+		server, fake, _ := newWebSubTestServer(t, websubStateExpired)
+		answerFeedSource(fake)
+
+		if status := pushContent(t, server, testPushedFeed, sign("sha256", sha256.New, testSecret, testPushedFeed)); status != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", status, http.StatusNotFound)
+		}
+	})
+}
+
+func TestRequestWebSubSubscription(t *testing.T) {
+	t.Setenv("WEBSUB_CALLBACK_URL", "https://speakrine.example.org/websub/")
+	t.Setenv("WEBSUB_LEASE_SECONDS", "7200")
+
+	// The hub stand-in accepts the subscription requests and keeps their form.
+	var form url.Values
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form = r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	subscription := websubSubscription{FeedId: 42, HubUrl: hub.URL, TopicUrl: testTopicUrl, Secret: testSecret, State: websubStateNew}
+	if err := requestWebSubSubscription(context.Background(), subscription); err != nil {
+		t.Fatalf("requestWebSubSubscription() error = %v", err)
+	}
+
+	want := map[string]string{
+		"hub.mode":          "subscribe",
+		"hub.topic":         testTopicUrl,
+		"hub.callback":      "https://speakrine.example.org/websub/42",
+		"hub.secret":        testSecret,
+		"hub.lease_seconds": "7200",
+	}
+	for key, value := range want {
+		if form.Get(key) != value {
+			t.Errorf("%v = %q, want %q", key, form.Get(key), value)
+		}
+	}
+}
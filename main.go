@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cl3mcg/speakrine/functions"
@@ -13,6 +16,11 @@ import (
 )
 
 func main() {
+	// Run a one-off command instead of the periodic process if one is given
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Get environment variables
 	strInterval := gowebly.Getenv("FETCH_INTERVAL", "")
 	if strInterval == "" {
@@ -33,37 +41,54 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create a ticker that ticks every interval
-	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	// Cancel the running fetches when the process is asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Initial run
-	slog.Info("Starting the rss feed fetching process")
-	err = functions.FetchAllRSSData()
-	if err != nil {
-		slog.Error("The process used to fetch RSS article content has failed", "error", err)
-	}
-	slog.Info("Ending the rss feed fetching process")
-	slog.Info("Starting the rss article cleaning process")
-	err = functions.CleanAllRSSData()
-	if err != nil {
-		slog.Error("The process used to clean RSS article content has failed", "error", err)
+	// Receive the updates pushed by the WebSub hubs, if configured
+	if err := functions.StartWebSubServer(ctx); err != nil {
+		slog.Error("The WebSub callback server cannot be started", "error", err)
+		os.Exit(1)
 	}
-	slog.Info("Ending the rss cleaning process")
 
-	// Periodic run
-	for range ticker.C {
+	// Create a ticker that ticks every interval
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+
+	// Initial run, then periodic runs until the process is stopped
+	for {
 		slog.Info("Starting the rss feed fetching process")
-		err := functions.FetchAllRSSData()
+		err := functions.FetchAllRSSData(ctx)
 		if err != nil {
 			slog.Error("The process used to fetch RSS article content has failed", "error", err)
 		}
 		slog.Info("Ending the rss feed fetching process")
+		err = functions.RenewWebSubSubscriptions(ctx)
+		if err != nil {
+			slog.Error("The process used to renew WebSub subscriptions has failed", "error", err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
 		slog.Info("Starting the rss article cleaning process")
-		err = functions.CleanAllRSSData()
+		err = functions.CleanAllRSSData(ctx)
 		if err != nil {
 			slog.Error("The process used to clean RSS article content has failed", "error", err)
 		}
 		slog.Info("Ending the rss cleaning process")
+		err = functions.PurgeOldItems(ctx)
+		if err != nil {
+			slog.Error("The process used to purge old RSS items has failed", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
+	slog.Info("Shutting down")
 }
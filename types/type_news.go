@@ -8,23 +8,56 @@ import (
 
 // RssItem represents a single RSS feed item with its details.
 type RssItem struct {
-	Id               int       // Unique identifier for the RSS item.
-	RssFeedId        int       // Identifier for the RSS feed to which this item belongs.
-	Title            string    // Title of the RSS item.
-	Link             string    // URL link to the full content of the RSS item.
-	Author           string    // Author of the RSS item.
-	SummaryRaw       string    // Short raw summary of the RSS item.
-	SummaryFormatted string    // Short formatted summary of the RSS item.
-	ContentRaw       string    // Full raw content of the RSS item.
-	ContentFormatted string    // Full formatted content of the RSS item.
-	PublishedDate    time.Time // Date and time when the RSS item was published.
-	UpdatedDate      time.Time // Date and time when the RSS item was last updated.
-	ExtractionDate   time.Time // Date and time when the RSS item was extracted.
-	Categories       []string  // List of categories associated with the RSS item.
-	IsRead           bool      // Flag indicating whether the RSS item has been read.
-	IsHidden         bool      // Flag indicating whether the RSS item is marked as 'hidden'.
-	PrevItemId       int       // Identifier for the previous RSS item in the feed.
-	NextItemId       int       // Identifier for the next RSS item in the feed.
+	Id               int            // Unique identifier for the RSS item.
+	RssFeedId        int            // Identifier for the RSS feed to which this item belongs.
+	Title            string         // Title of the RSS item.
+	Link             string         // URL link to the full content of the RSS item.
+	Author           string         // Author of the RSS item.
+	SummaryRaw       string         // Short raw summary of the RSS item.
+	SummaryFormatted string         // Short formatted summary of the RSS item.
+	ContentRaw       string         // Full raw content of the RSS item.
+	ContentFormatted string         // Full formatted content of the RSS item.
+	PublishedDate    time.Time      // Date and time when the RSS item was published.
+	UpdatedDate      time.Time      // Date and time when the RSS item was last updated.
+	ExtractionDate   time.Time      // Date and time when the RSS item was extracted.
+	Categories       []string       // List of categories associated with the RSS item.
+	Media            []RssItemMedia // List of media files (podcast episodes, videos...) attached to the RSS item.
+	IsRead           bool           // Flag indicating whether the RSS item has been read.
+	IsHidden         bool           // Flag indicating whether the RSS item is marked as 'hidden'.
+	IsStarred        bool           // Flag indicating whether the RSS item is starred, starred items are never purged.
+	IsPinned         bool           // Flag indicating whether the RSS item is pinned, pinned items are never purged.
+	NeedsReview      bool           // Flag indicating whether the formatted content may not be faithful to the raw content.
+	FidelityIssue    string         // Why the formatted content was found unfaithful to the raw content, if it was.
+	PrevItemId       int            // Identifier for the previous RSS item in the feed.
+	NextItemId       int            // Identifier for the next RSS item in the feed.
+}
+
+// RssItemMedia represents a media file attached to an RSS item, such as a podcast episode or a video.
+type RssItemMedia struct {
+	Id         int    // Unique identifier for the media.
+	RssItemId  int    // Identifier for the RSS item to which this media belongs.
+	Url        string // URL of the media file.
+	MimeType   string // MIME type of the media file (e.g., audio/mpeg).
+	Medium     string // Kind of media (audio, video, image, other).
+	Length     int64  // Size of the media file in bytes, 0 if unknown.
+	Duration   int    // Duration of the media in seconds, 0 if unknown.
+	Episode    int    // Episode number of a podcast episode, 0 if unknown.
+	Season     int    // Season number of a podcast episode, 0 if unknown.
+	ArtworkUrl string // URL of the artwork of the media.
+}
+
+// Kind returns the kind of an RSS item: "audio" or "video" when it carries such a media, "article" otherwise.
+func (i *RssItem) Kind() string {
+	kind := "article"
+	for _, media := range i.Media {
+		switch media.Medium {
+		case "video":
+			return "video"
+		case "audio":
+			kind = "audio"
+		}
+	}
+	return kind
 }
 
 // RssFeed represents an RSS feed, containing metadata and its list of entries.
@@ -36,11 +69,25 @@ type RssFeed struct {
 	Url             string    // URL of the RSS feed.
 	LastPublication time.Time // Last time an article was published in the RSS feed.
 	LastUpdate      time.Time // Last time the RSS feed was updated.
+	Status          string    // Fetch status of the RSS feed (active, suspended, retired).
+	LastError       string    // Error returned by the last failed fetch of the RSS feed.
+	LastHttpStatus  int       // HTTP status code returned by the last fetch of the RSS feed.
+	LastSuccess     time.Time // Last time the RSS feed was fetched successfully.
+	FailureCount    int       // Number of fetches of the RSS feed that failed in a row.
+	LastRepair      string    // Repairs applied to the last fetched document of the RSS feed before it could be parsed.
+	LastRepairAt    time.Time // Last time the document of the RSS feed had to be repaired.
 	Categories      []string  // List of categories associated with the RSS feed.
 	EntriesUnread   int       // Number of unread entries in the RSS feed.
 	Entries         []RssItem // List of RSS feed items (entries).
 }
 
+// FeedCandidate represents a feed found by the autodiscovery of a website, ready to be subscribed to.
+type FeedCandidate struct {
+	Url      string // URL of the feed.
+	Title    string // Title of the feed.
+	FeedType string // Type of feed (rss, atom, json).
+}
+
 // OrderEntries sorts the Entries of an RssFeed in reverse chronological order.
 //
 // The Entries slice is reordered in-place, with the most recent RssItem (by PublishedDate)